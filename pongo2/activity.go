@@ -1,6 +1,7 @@
 package pongo2
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -12,9 +13,49 @@ import (
 // activityMd holds the metadata for this activity
 var activityMd = activity.ToMetadata(&Settings{}, &Input{}, &Output{})
 
-// Activity represents the pongo2-prompt activity
+// Activity represents the pongo2-prompt activity. The template setting is
+// parsed once in New and templates passed as input are kept in an LRU
+// cache; compiled templates are read-only and shared by concurrent Evals.
 type Activity struct {
-	template string
+	set *templateSet
+	// template is the template setting, nil when it is empty and the
+	// template comes from the input
+	template *preparedTemplate
+	// inputs compiles template inputs, sandboxed to the template directory
+	inputs *templateSet
+	cache  *templateCache
+
+	// strict makes Eval validate its input against the template schema
+	// instead of rendering with blanks
+	strict bool
+	// messageFormat is the JSON shape of the messages output
+	messageFormat string
+	// tokenBudget is the token limit reported by withinBudget, 0 for none
+	tokenBudget int
+	// countTokens sets the tokenCount output; it is implied by a budget
+	countTokens bool
+}
+
+// preparedTemplate is a compiled template with the inputs found in it
+type preparedTemplate struct {
+	source    string // template text or path, for log messages
+	compiled  *pongo2.Template
+	variables []string
+	schema    map[string]interface{}
+}
+
+// prepare analyzes a compiled template of the activity's template set
+func (set *templateSet) prepare(source string, compiled *pongo2.Template) (*preparedTemplate, error) {
+	analysis, err := set.analyze(compiled)
+	if err != nil {
+		return nil, err
+	}
+	return &preparedTemplate{
+		source:    source,
+		compiled:  compiled,
+		variables: analysis.Variables,
+		schema:    buildInputSchema(analysis),
+	}, nil
 }
 
 func init() {
@@ -26,16 +67,78 @@ func (a *Activity) Metadata() *activity.Metadata {
 	return activityMd
 }
 
-// New creates a new instance of the Activity.
+// New creates a new instance of the Activity. The template is loaded,
+// parsed and analyzed here so syntax errors and missing template files fail
+// the flow at startup. The template setting may be left empty when every
+// Eval passes a template input.
 func New(ctx activity.InitContext) (activity.Activity, error) {
-	// No initialization needed since template is now an input
 	s := &Settings{}
 	err := metadata.MapToStruct(ctx.Settings(), s, true)
 	if err != nil {
 		return nil, err
 	}
-	ctx.Logger().Info("Pongo2-prompt activity initialized - template will be processed at runtime")
-	return &Activity{template: s.Template}, nil
+
+	if s.TemplateCacheSize < 0 {
+		return nil, fmt.Errorf("templateCacheSize cannot be negative")
+	}
+	cacheSize := s.TemplateCacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultTemplateCacheSize
+	}
+
+	if s.TokenBudget < 0 {
+		return nil, fmt.Errorf("tokenBudget cannot be negative")
+	}
+
+	switch s.MessageFormat {
+	case "":
+		s.MessageFormat = messageFormatOpenAI
+	case messageFormatOpenAI, messageFormatAnthropic:
+	default:
+		return nil, fmt.Errorf("invalid messageFormat '%s': must be '%s' or '%s'", s.MessageFormat, messageFormatOpenAI, messageFormatAnthropic)
+	}
+
+	if len(filterConflicts) > 0 {
+		ctx.Logger().Warnf("Filters registered by another package are used instead of the activity's own: %v", filterConflicts)
+	}
+
+	set, compiled, err := loadTemplate(s)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := newInputSet(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var template *preparedTemplate
+	if compiled != nil {
+		template, err = set.prepare(templateDescription(s), compiled)
+		if err != nil {
+			return nil, err
+		}
+		if len(template.variables) > 0 {
+			ctx.Logger().Infof("Template variables detected: %v", template.variables)
+			if schemaJSON, err := json.Marshal(template.schema); err == nil {
+				ctx.Logger().Debugf("Generated JSON schema: %s", schemaJSON)
+			}
+		}
+		ctx.Logger().Info("Pongo2-prompt activity initialized - template compiled")
+	} else {
+		ctx.Logger().Info("Pongo2-prompt activity initialized - template expected as input")
+	}
+
+	return &Activity{
+		set:           set,
+		template:      template,
+		inputs:        inputs,
+		cache:         newTemplateCache(cacheSize),
+		strict:        s.StrictVariables,
+		messageFormat: s.MessageFormat,
+		tokenBudget:   s.TokenBudget,
+		countTokens:   s.CountTokens || s.TokenBudget > 0,
+	}, nil
 }
 
 // Eval executes the activity
@@ -44,19 +147,18 @@ func (a *Activity) Eval(ctx activity.Context) (bool, error) {
 		return false, fmt.Errorf("activity context is nil")
 	}
 
-	// Get the template from input
-	templateInput := a.template
-
-	if templateInput == "" {
-		return false, fmt.Errorf("template cannot be empty")
+	// A template input overrides the template setting for this Eval
+	template := a.template
+	if override, ok := ctx.GetInput("template").(string); ok && override != "" {
+		prepared, cached, err := a.cache.get(override, a.compileInput)
+		if err != nil {
+			return false, err
+		}
+		ctx.Logger().Debugf("Using template from input (cached: %t)", cached)
+		template = prepared
 	}
-
-	// Log template analysis for debugging
-	variables := extractTemplateVariables(templateInput)
-	if len(variables) > 0 {
-		ctx.Logger().Infof("Template variables detected: %v", variables)
-		schema := generateJSONSchemaForTemplate(templateInput)
-		ctx.Logger().Debugf("Generated JSON schema: %s", schema)
+	if template == nil {
+		return false, fmt.Errorf("template cannot be empty: set the template setting or pass a template input")
 	}
 
 	// Create a context for template variables
@@ -67,7 +169,7 @@ func (a *Activity) Eval(ctx activity.Context) (bool, error) {
 		if tvMap, ok := templateVariablesInput.(map[string]interface{}); ok {
 			for key, value := range tvMap {
 				if value != nil { // Only include non-nil values
-					templateVars[key] = value
+					templateVars[key], _ = withoutMessageMarkers(value)
 				}
 			}
 			ctx.Logger().Debugf("Loaded %d template variables from input fields", len(templateVars))
@@ -80,12 +182,16 @@ func (a *Activity) Eval(ctx activity.Context) (bool, error) {
 		ctx.Logger().Debugf("Template context prepared with %d variables", len(templateVars))
 	}
 
-	// Extract expected variables for validation and user feedback
-	expectedVars := extractTemplateVariables(templateInput)
-	if len(expectedVars) > 0 {
-		// Check for missing variables and warn
+	// In strict mode the input must match the schema generated from the
+	// template
+	if a.strict {
+		if violations := validateVariables(template.schema, templateVars); len(violations) > 0 {
+			return false, fmt.Errorf("template variables do not match the template: %s", strings.Join(violations, "; "))
+		}
+	} else if len(template.variables) > 0 {
+		// Check for the variables found in the template and warn when missing
 		var missingVars []string
-		for _, expectedVar := range expectedVars {
+		for _, expectedVar := range template.variables {
 			if _, exists := templateVars[expectedVar]; !exists {
 				missingVars = append(missingVars, expectedVar)
 			}
@@ -96,25 +202,29 @@ func (a *Activity) Eval(ctx activity.Context) (bool, error) {
 		}
 	}
 
-	// Parse and render the template
-	template, err := pongo2.FromString(templateInput)
+	renderedPrompt, err := template.compiled.Execute(templateVars)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse template: %w", err)
+		return false, fmt.Errorf("failed to render template: %w", err)
 	}
 
-	renderedPrompt, err := template.Execute(templateVars)
-	if err != nil {
-		return false, fmt.Errorf("failed to render template: %w", err)
+	// Sections marked with message tags become chat messages; without them
+	// the whole prompt is a single user message
+	text, messages, stray := splitMessages(renderedPrompt)
+	if stray != "" {
+		ctx.Logger().Warnf("Text outside message tags is not part of any message: '%s'", stray)
 	}
 
-	trimmedOutput := strings.TrimSpace(renderedPrompt)
+	trimmedOutput := strings.TrimSpace(text)
+	if messages == nil && trimmedOutput != "" {
+		messages = []chatMessage{{Role: roleUser, Content: trimmedOutput}}
+	}
 	ctx.Logger().Debugf("Template rendered successfully, original output length: %d characters", len(renderedPrompt))
 	ctx.Logger().Debugf("After trimming, output length: %d characters", len(trimmedOutput))
 
 	// Check if output is empty and log detailed information
 	if len(trimmedOutput) == 0 {
 		ctx.Logger().Warnf("WARNING: Rendered output is empty!")
-		ctx.Logger().Warnf("Original template: '%s'", templateInput)
+		ctx.Logger().Warnf("Original template: '%s'", template.source)
 		ctx.Logger().Warnf("Template variables provided: %v", templateVars)
 		ctx.Logger().Warnf("Raw rendered output: '%s'", renderedPrompt)
 	} else {
@@ -125,5 +235,36 @@ func (a *Activity) Eval(ctx activity.Context) (bool, error) {
 	ctx.SetOutput("renderedPrompt", trimmedOutput)
 	ctx.Logger().Debugf("Output 'renderedPrompt' has been set successfully")
 
+	messageList, system := formatMessages(messages, a.messageFormat)
+	ctx.SetOutput("messages", messageList)
+	ctx.SetOutput("system", system)
+	ctx.Logger().Debugf("Output 'messages' has been set with %d messages", len(messageList))
+
+	// Tokenizing a long prompt costs more than rendering it, so it is only
+	// done when the count is used
+	tokenCount, withinBudget := 0, true
+	if a.countTokens {
+		if tokenCount, err = countTokens(trimmedOutput); err != nil {
+			return false, err
+		}
+		withinBudget = a.tokenBudget == 0 || tokenCount <= a.tokenBudget
+	}
+	if !withinBudget {
+		ctx.Logger().Warnf("Rendered prompt has %d tokens, over the budget of %d", tokenCount, a.tokenBudget)
+	}
+	ctx.SetOutput("tokenCount", tokenCount)
+	ctx.SetOutput("withinBudget", withinBudget)
+
 	return true, nil
 }
+
+// compileInput parses a template passed as input in the input template set,
+// so it can extend and include the prompt library in templateBaseDir but
+// no other files
+func (a *Activity) compileInput(content string) (*preparedTemplate, error) {
+	compiled, err := a.inputs.fromString(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template input: %w", err)
+	}
+	return a.inputs.prepare(content, compiled)
+}
@@ -1,8 +1,11 @@
 package pongo2
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
+	"github.com/flosch/pongo2/v6"
 	"github.com/project-flogo/core/activity"
 	"github.com/project-flogo/core/data"
 	"github.com/project-flogo/core/data/mapper"
@@ -276,6 +279,130 @@ Please provide your analysis with clear methodology, findings, and actionable in
 	}
 }
 
+func TestNewRejectsInvalidTemplate(t *testing.T) {
+	// Syntax errors are reported when the activity is created, not per Eval
+	initCtx := &MockInitContext{
+		settings: map[string]interface{}{
+			"template": "Hello {% if name %}{{ name }}",
+		},
+	}
+
+	_, err := New(initCtx)
+	if err == nil {
+		t.Fatalf("Expected an error for a template with an unclosed if tag")
+	}
+	if !contains(err.Error(), "failed to parse template") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestConcurrentEval(t *testing.T) {
+	// The compiled template is shared by all Evals of the activity
+	initCtx := &MockInitContext{
+		settings: map[string]interface{}{
+			"template": "Hello {{ name }}!{% for item in items %} {{ item }}{% endfor %}",
+		},
+	}
+
+	act, err := New(initCtx)
+	if err != nil {
+		t.Fatalf("Failed to create activity: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			evalCtx := &MockActivityContext{
+				inputs: map[string]interface{}{
+					"templateVariables": map[string]interface{}{
+						"name":  fmt.Sprintf("user%d", i),
+						"items": []interface{}{i, i + 1},
+					},
+				},
+				outputs: make(map[string]interface{}),
+			}
+			if _, err := act.Eval(evalCtx); err != nil {
+				errs <- err
+				return
+			}
+			expected := fmt.Sprintf("Hello user%d! %d %d", i, i, i+1)
+			if result := evalCtx.outputs["renderedPrompt"]; result != expected {
+				errs <- fmt.Errorf("expected '%s', got '%v'", expected, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// benchmarkTemplate is a typical prompt with conditions and a loop
+const benchmarkTemplate = `You are a {{ role }}.
+{% if context %}Context: {{ context }}{% endif %}
+Tasks:
+{% for task in tasks %}{{ forloop.Counter }}. {{ task }}
+{% endfor %}
+Please respond with {{ format }}.`
+
+func benchmarkContext() *MockActivityContext {
+	return &MockActivityContext{
+		inputs: map[string]interface{}{
+			"templateVariables": map[string]interface{}{
+				"role":    "helpful AI assistant",
+				"context": "customer feedback",
+				"tasks":   []interface{}{"summarize", "classify", "suggest actions"},
+				"format":  "bullet points",
+			},
+		},
+		outputs: make(map[string]interface{}),
+	}
+}
+
+// BenchmarkEval measures an Eval with the template compiled in New
+func BenchmarkEval(b *testing.B) {
+	act, err := New(&MockInitContext{settings: map[string]interface{}{"template": benchmarkTemplate}})
+	if err != nil {
+		b.Fatalf("Failed to create activity: %v", err)
+	}
+	ctx := benchmarkContext()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := act.Eval(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEvalParsePerCall reproduces the previous Eval, which analyzed the
+// template twice and parsed it on every call, for comparison with BenchmarkEval
+func BenchmarkEvalParsePerCall(b *testing.B) {
+	ctx := benchmarkContext()
+	vars := pongo2.Context(ctx.inputs["templateVariables"].(map[string]interface{}))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extractTemplateVariables(benchmarkTemplate)
+		generateJSONSchemaForTemplate(benchmarkTemplate)
+		extractTemplateVariables(benchmarkTemplate)
+		tpl, err := pongo2.FromString(benchmarkTemplate)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := tpl.Execute(vars); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || containsInMiddle(s, substr)))
 }
@@ -489,3 +616,80 @@ func TestJSONSchemaGeneration(t *testing.T) {
 
 	t.Logf("Generated schema: %s", schema)
 }
+
+func TestStrictVariables(t *testing.T) {
+	initCtx := &MockInitContext{
+		settings: map[string]interface{}{
+			"template":        "{{ task }}{% if context %} ({{ context }}){% endif %}{% for s in steps %}\n- {{ s.title }}{% endfor %}",
+			"strictVariables": true,
+		},
+	}
+
+	act, err := New(initCtx)
+	if err != nil {
+		t.Fatalf("Failed to create activity: %v", err)
+	}
+
+	evalCtx := &MockActivityContext{
+		inputs: map[string]interface{}{
+			"templateVariables": map[string]interface{}{
+				"steps": []interface{}{map[string]interface{}{"name": "plan"}},
+			},
+		},
+		outputs: make(map[string]interface{}),
+	}
+	_, err = act.Eval(evalCtx)
+	if err == nil {
+		t.Fatalf("Expected strict validation to fail")
+	}
+	expected := "template variables do not match the template: steps[0].title: required variable is missing; task: required variable is missing"
+	if err.Error() != expected {
+		t.Errorf("Expected error '%s', got '%v'", expected, err)
+	}
+	if _, ok := evalCtx.outputs["renderedPrompt"]; ok {
+		t.Errorf("Expected no output when validation fails")
+	}
+
+	// Optional variables may be left out
+	evalCtx = &MockActivityContext{
+		inputs: map[string]interface{}{
+			"templateVariables": map[string]interface{}{
+				"task":  "Review",
+				"steps": []interface{}{map[string]interface{}{"title": "plan"}},
+			},
+		},
+		outputs: make(map[string]interface{}),
+	}
+	if _, err := act.Eval(evalCtx); err != nil {
+		t.Fatalf("Eval failed: %v", err)
+	}
+	if result := evalCtx.outputs["renderedPrompt"]; result != "Review\n- plan" {
+		t.Errorf("Unexpected output: %q", result)
+	}
+}
+
+func TestStrictVariablesBranches(t *testing.T) {
+	act, err := New(&MockInitContext{
+		settings: map[string]interface{}{
+			"template":        "{% if a %}{{ a }}{% else %}{{ b }}{% endif %}{% firstof c d %}",
+			"strictVariables": true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create activity: %v", err)
+	}
+
+	// b is only rendered by the else branch, d only when c is not set
+	evalCtx := &MockActivityContext{
+		inputs: map[string]interface{}{
+			"templateVariables": map[string]interface{}{"a": "x", "c": "y"},
+		},
+		outputs: make(map[string]interface{}),
+	}
+	if _, err := act.Eval(evalCtx); err != nil {
+		t.Fatalf("Eval failed: %v", err)
+	}
+	if result := evalCtx.outputs["renderedPrompt"]; result != "xy" {
+		t.Errorf("Unexpected output: %q", result)
+	}
+}
@@ -0,0 +1,520 @@
+package pongo2
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// pongo2Version is the pongo2 release whose unexported template fields
+// templateTokens and templateOrigin read; go.mod pins it
+const pongo2Version = "v6.0.0"
+
+// templateAnalysis describes the inputs of a template, found by walking the
+// tokens pongo2's own lexer produced for it
+type templateAnalysis struct {
+	// Variables are the free root variables, in order of first use
+	Variables []string
+	// Paths are the attribute paths used on free variables, e.g.
+	// customer.address.city. "[]" marks the items of an array that is
+	// iterated or indexed, "{}" the values of an object iterated with
+	// two loop variables.
+	Paths []string
+	// LoopVars are the names bound by for loops; they are not inputs
+	LoopVars []string
+	// Filters are the filters applied, in order of first use
+	Filters []string
+	// Optional are the paths only used where a missing value is expected:
+	// in if conditions and firstof arguments, inside any branch of an if
+	// block, and in expressions with the default filter
+	Optional []string
+	// References are the variable reads outside conditions and default
+	// filters, as written in the template
+	References []templateReference
+}
+
+// templateReference is a variable read such as {{ order.lines.0.sku }}
+type templateReference struct {
+	Path  string        // as written, e.g. order.lines.0.sku
+	Line  int           // position of the variable
+	Col   int           //
+	Block templateToken // the {{ or {% that opens the enclosing block
+}
+
+// templateToken is a token of a parsed pongo2 template
+type templateToken struct {
+	Typ  pongo2.TokenType
+	Val  string
+	Line int
+	Col  int
+}
+
+// analyzeTemplate parses the template and returns its inputs. Templates
+// that do not compile return the parse error.
+func analyzeTemplate(template string) (*templateAnalysis, error) {
+	tpl, err := pongo2.FromString(template)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := templateTokens(tpl)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeTokens(tokens), nil
+}
+
+// templateTokens reads the token list of a compiled template. pongo2 keeps
+// it unexported, so it is read through reflection and fails when a pongo2
+// release renames or retypes it; the fields of Token itself are exported
+// and read directly, so changes to them fail the build.
+func templateTokens(tpl *pongo2.Template) ([]templateToken, error) {
+	field := reflect.ValueOf(tpl).Elem().FieldByName("tokens")
+	if !field.IsValid() || field.Type() != reflect.TypeOf([]*pongo2.Token(nil)) {
+		return nil, fmt.Errorf("token list of pongo2 template is not available; template analysis supports pongo2 %s", pongo2Version)
+	}
+
+	tokens := make([]templateToken, 0, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		// An unexported field cannot be read with Interface, so the token
+		// is accessed through its address
+		token := (*pongo2.Token)(unsafe.Pointer(field.Index(i).Pointer()))
+		tokens = append(tokens, templateToken{
+			Typ:  token.Typ,
+			Val:  token.Val,
+			Line: token.Line,
+			Col:  token.Col,
+		})
+	}
+	return tokens, nil
+}
+
+// analysisScope maps names bound by tags (for, with, set, macro, ...) to the
+// input path they stand for, or "" when they do not refer to an input
+type analysisScope map[string]string
+
+type analyzer struct {
+	scopes []analysisScope
+	result *templateAnalysis
+	seen   map[string]bool
+
+	// branches counts the enclosing if blocks; what they render depends
+	// on the condition, so their paths are not required
+	branches int
+	// guarded is set while evaluating a condition or a defaulted expression
+	guarded bool
+	// required records paths that are used without a guard
+	required map[string]bool
+	// block is the token opening the block being analyzed
+	block templateToken
+}
+
+// analyzeTokens walks the tags and variable blocks of a token stream
+func analyzeTokens(tokens []templateToken) *templateAnalysis {
+	a := &analyzer{
+		scopes:   []analysisScope{{}},
+		result:   &templateAnalysis{},
+		seen:     make(map[string]bool),
+		required: make(map[string]bool),
+	}
+
+	for pos := 0; pos < len(tokens); pos++ {
+		var closing string
+		switch {
+		case isSymbol(tokens[pos], "{{"):
+			closing = "}}"
+		case isSymbol(tokens[pos], "{%"):
+			closing = "%}"
+		default:
+			continue
+		}
+
+		end := pos + 1
+		for end < len(tokens) && !isSymbol(tokens[end], closing) {
+			end++
+		}
+		args := tokens[pos+1 : end]
+		a.block = tokens[pos]
+
+		if closing == "}}" {
+			a.expression(args)
+		} else if len(args) > 0 && args[0].Val == "comment" {
+			// Everything up to endcomment is text
+			for end < len(tokens) && !(isSymbol(tokens[end], "{%") && end+1 < len(tokens) && tokens[end+1].Val == "endcomment") {
+				end++
+			}
+		} else {
+			a.tag(args)
+		}
+		pos = end
+	}
+
+	for _, path := range a.result.Paths {
+		if !a.required[path] {
+			a.result.Optional = append(a.result.Optional, path)
+		}
+	}
+	return a.result
+}
+
+// tag handles the arguments of a {% ... %} tag, binding the names it
+// introduces
+func (a *analyzer) tag(args []templateToken) {
+	if len(args) == 0 {
+		return
+	}
+	name, rest := args[0].Val, args[1:]
+
+	switch name {
+	case "for":
+		a.forTag(rest)
+	case "if", "ifequal", "ifnotequal":
+		a.condition(rest)
+		a.branches++
+	case "firstof", "elif":
+		// firstof outputs the first of its arguments that is set
+		a.condition(rest)
+	case "endif", "endifequal", "endifnotequal":
+		if a.branches > 0 {
+			a.branches--
+		}
+	case "endfor", "endwith", "endmacro":
+		if len(a.scopes) > 1 {
+			a.scopes = a.scopes[:len(a.scopes)-1]
+		}
+	case "with":
+		bindings := a.assignments(rest)
+		a.scopes = append(a.scopes, bindings)
+	case "set":
+		for name, path := range a.assignments(rest) {
+			a.scopes[len(a.scopes)-1][name] = path
+		}
+	case "macro":
+		a.macroTag(rest)
+	case "import":
+		// Imported macros and their aliases are names, not inputs
+		for _, token := range rest {
+			if token.Typ == pongo2.TokenIdentifier {
+				a.scopes[0][token.Val] = ""
+			}
+		}
+	case "include":
+		for i, token := range rest {
+			if token.Typ == pongo2.TokenIdentifier && token.Val == "with" {
+				a.expression(rest[:i])
+				a.assignments(rest[i+1:])
+				return
+			}
+		}
+		a.expression(rest)
+	case "cycle":
+		for i, token := range rest {
+			if token.Typ == pongo2.TokenKeyword && token.Val == "as" {
+				a.expression(rest[:i])
+				if i+1 < len(rest) {
+					a.scopes[len(a.scopes)-1][rest[i+1].Val] = ""
+				}
+				return
+			}
+		}
+		a.expression(rest)
+	case "filter":
+		if len(rest) > 0 {
+			a.addFilter(rest[0].Val)
+			a.expression(rest[1:])
+		}
+	case "block", "endblock", "extends", "templatetag", "now", "lorem", "autoescape", "ssi":
+		// Arguments are names or literals
+	default:
+		a.expression(rest)
+	}
+}
+
+// forTag binds the loop variables of {% for x in expr %} and
+// {% for key, value in expr %} to the items of the iterated path
+func (a *analyzer) forTag(args []templateToken) {
+	in := -1
+	var names []string
+	for i, token := range args {
+		if token.Typ == pongo2.TokenKeyword && token.Val == "in" {
+			in = i
+			break
+		}
+		if token.Typ == pongo2.TokenIdentifier {
+			names = append(names, token.Val)
+		}
+	}
+	if in < 0 {
+		return
+	}
+
+	iterable := args[in+1:]
+	for len(iterable) > 0 {
+		last := iterable[len(iterable)-1]
+		if last.Typ != pongo2.TokenIdentifier || (last.Val != "reversed" && last.Val != "sorted") {
+			break
+		}
+		iterable = iterable[:len(iterable)-1]
+	}
+
+	paths := a.expression(iterable)
+	path := ""
+	if len(iterable) > 0 && iterable[0].Typ == pongo2.TokenIdentifier && len(paths) > 0 {
+		path = paths[0]
+	}
+
+	scope := analysisScope{"forloop": ""}
+	for i, name := range names {
+		a.addLoopVar(name)
+		scope[name] = ""
+		if path == "" {
+			continue
+		}
+		switch {
+		case len(names) == 1:
+			scope[name] = a.addPath(path + "[]")
+		case i == 1:
+			scope[name] = a.addPath(path + "{}")
+		}
+	}
+	a.scopes = append(a.scopes, scope)
+}
+
+// macroTag binds the macro name globally and its arguments until endmacro
+func (a *analyzer) macroTag(args []templateToken) {
+	if len(args) == 0 {
+		return
+	}
+	a.scopes[0][args[0].Val] = ""
+
+	scope := analysisScope{}
+	for i := 1; i < len(args); i++ {
+		token := args[i]
+		if token.Typ != pongo2.TokenIdentifier {
+			continue
+		}
+		previous := args[i-1]
+		if isSymbol(previous, "(") || isSymbol(previous, ",") {
+			scope[token.Val] = ""
+			continue
+		}
+		// Default values are expressions
+		a.expression(args[i : i+1])
+	}
+	a.scopes = append(a.scopes, scope)
+}
+
+// assignments handles name=expr lists and the "expr as name" form, returning
+// the bound names
+func (a *analyzer) assignments(args []templateToken) analysisScope {
+	bindings := analysisScope{}
+
+	for i, token := range args {
+		if token.Typ == pongo2.TokenKeyword && token.Val == "as" && i+1 < len(args) {
+			bindings[args[i+1].Val] = a.simplePath(args[:i])
+			return bindings
+		}
+	}
+
+	for i := 0; i < len(args); {
+		if args[i].Typ != pongo2.TokenIdentifier || i+1 >= len(args) || !isSymbol(args[i+1], "=") {
+			i++
+			continue
+		}
+		// The value runs until the next name=
+		end := i + 2
+		for end < len(args) && !(args[end].Typ == pongo2.TokenIdentifier && end+1 < len(args) && isSymbol(args[end+1], "=")) {
+			end++
+		}
+		bindings[args[i].Val] = a.simplePath(args[i+2 : end])
+		i = end
+	}
+	return bindings
+}
+
+// simplePath evaluates an expression and returns its path when the
+// expression starts with a variable, so aliases keep pointing at the input
+func (a *analyzer) simplePath(expr []templateToken) string {
+	paths := a.expression(expr)
+	if len(expr) > 0 && expr[0].Typ == pongo2.TokenIdentifier && len(paths) > 0 {
+		return paths[0]
+	}
+	return ""
+}
+
+// condition evaluates an expression whose variables may be missing, such as
+// the condition of an if or elif tag
+func (a *analyzer) condition(tokens []templateToken) {
+	previous := a.guarded
+	a.guarded = true
+	defer func() { a.guarded = previous }()
+	a.expression(tokens)
+}
+
+// isGuarded reports whether a use tolerates a missing value: it is a check
+// itself or only rendered when a condition holds
+func (a *analyzer) isGuarded() bool {
+	return a.guarded || a.branches > 0
+}
+
+// expression records the variables and filters of an expression and
+// returns the resolved path of every variable reference in it
+func (a *analyzer) expression(tokens []templateToken) []string {
+	var paths []string
+
+	// A default filter supplies a value when the input is missing
+	for i := 1; i < len(tokens); i++ {
+		if isSymbol(tokens[i-1], "|") && (tokens[i].Val == "default" || tokens[i].Val == "default_if_none") {
+			previous := a.guarded
+			a.guarded = true
+			defer func() { a.guarded = previous }()
+			break
+		}
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.Typ != pongo2.TokenIdentifier {
+			continue
+		}
+		if i > 0 && isSymbol(tokens[i-1], "|") {
+			a.addFilter(token.Val)
+			continue
+		}
+		if i > 0 && isSymbol(tokens[i-1], ".") {
+			// Attribute of a call result such as fn().name
+			continue
+		}
+		if i+1 < len(tokens) && isSymbol(tokens[i+1], "=") {
+			// Keyword argument name
+			continue
+		}
+		if token.Val == "nil" {
+			continue
+		}
+
+		segments := []string{token.Val}
+		written := []string{token.Val}
+		for i+2 < len(tokens) && isSymbol(tokens[i+1], ".") &&
+			(tokens[i+2].Typ == pongo2.TokenIdentifier || tokens[i+2].Typ == pongo2.TokenNumber) {
+			if tokens[i+2].Typ == pongo2.TokenNumber {
+				segments = append(segments, "[]")
+			} else {
+				segments = append(segments, tokens[i+2].Val)
+			}
+			written = append(written, tokens[i+2].Val)
+			i += 2
+		}
+		// Calls are checked by pongo2 itself
+		if !a.guarded && !(i+1 < len(tokens) && isSymbol(tokens[i+1], "(")) {
+			a.result.References = append(a.result.References, templateReference{
+				Path:  strings.Join(written, "."),
+				Line:  token.Line,
+				Col:   token.Col,
+				Block: a.block,
+			})
+		}
+		paths = append(paths, a.resolve(segments))
+	}
+
+	return paths
+}
+
+// resolve maps a variable reference to an input path. Names bound by a tag
+// are replaced by the path they stand for; other roots are free variables.
+func (a *analyzer) resolve(segments []string) string {
+	base, bound := "", false
+	for i := len(a.scopes) - 1; i >= 0; i-- {
+		if path, ok := a.scopes[i][segments[0]]; ok {
+			base, bound = path, true
+			break
+		}
+	}
+	if bound && base == "" {
+		return ""
+	}
+	if !bound {
+		a.addVariable(segments[0])
+		base = segments[0]
+	}
+
+	var path strings.Builder
+	path.WriteString(base)
+	for _, segment := range segments[1:] {
+		if segment != "[]" {
+			path.WriteString(".")
+		}
+		path.WriteString(segment)
+	}
+	return a.addPath(path.String())
+}
+
+func (a *analyzer) addVariable(name string) {
+	if !a.seen["var:"+name] {
+		a.seen["var:"+name] = true
+		a.result.Variables = append(a.result.Variables, name)
+	}
+}
+
+func (a *analyzer) addPath(path string) string {
+	if !a.isGuarded() {
+		a.required[path] = true
+	}
+	if !a.seen["path:"+path] {
+		a.seen["path:"+path] = true
+		a.result.Paths = append(a.result.Paths, path)
+	}
+	return path
+}
+
+func (a *analyzer) addLoopVar(name string) {
+	if !a.seen["loop:"+name] {
+		a.seen["loop:"+name] = true
+		a.result.LoopVars = append(a.result.LoopVars, name)
+	}
+}
+
+func (a *analyzer) addFilter(name string) {
+	if !a.seen["filter:"+name] {
+		a.seen["filter:"+name] = true
+		a.result.Filters = append(a.result.Filters, name)
+	}
+}
+
+// arrays returns the free variables that are iterated or indexed directly
+func (r *templateAnalysis) arrays() []string {
+	var arrays []string
+	for _, variable := range r.Variables {
+		for _, path := range r.Paths {
+			if strings.HasPrefix(path, variable+"[]") {
+				arrays = append(arrays, variable)
+				break
+			}
+		}
+	}
+	return arrays
+}
+
+func isSymbol(token templateToken, val string) bool {
+	return token.Typ == pongo2.TokenSymbol && token.Val == val
+}
+
+// templateReferences returns the names of the templates an extends, include
+// or import tag refers to with a string literal
+func templateReferences(tokens []templateToken) []string {
+	var refs []string
+	for i := 0; i+2 < len(tokens); i++ {
+		if !isSymbol(tokens[i], "{%") {
+			continue
+		}
+		switch tokens[i+1].Val {
+		case "extends", "include", "import":
+			if tokens[i+2].Typ == pongo2.TokenString {
+				refs = append(refs, tokens[i+2].Val)
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,194 @@
+package pongo2
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		variables []string
+		paths     []string
+		loopVars  []string
+		filters   []string
+	}{
+		{
+			name:      "attribute access",
+			template:  "{{ user.name }} lives in {{ user.address.city }}",
+			variables: []string{"user"},
+			paths:     []string{"user.name", "user.address.city"},
+		},
+		{
+			name:      "arithmetic",
+			template:  "{{ a + b * 2 }}",
+			variables: []string{"a", "b"},
+			paths:     []string{"a", "b"},
+		},
+		{
+			name:      "filters and arguments",
+			template:  `{{ title|default:fallback|upper }} {{ price|floatformat:2 }}`,
+			variables: []string{"title", "fallback", "price"},
+			paths:     []string{"title", "fallback", "price"},
+			filters:   []string{"default", "upper", "floatformat"},
+		},
+		{
+			name:      "loop iterator is not an input",
+			template:  "{% for item in items %}{{ item.name }} {{ forloop.Counter }}{% endfor %}{{ item }}",
+			variables: []string{"items", "item"},
+			paths:     []string{"items", "items[]", "items[].name", "item"},
+			loopVars:  []string{"item"},
+		},
+		{
+			name:      "nested loops",
+			template:  "{% for o in customer.orders reversed %}{% for l in o.lines %}{{ l.sku }}{% endfor %}{% endfor %}",
+			variables: []string{"customer"},
+			paths:     []string{"customer.orders", "customer.orders[]", "customer.orders[].lines", "customer.orders[].lines[]", "customer.orders[].lines[].sku"},
+			loopVars:  []string{"o", "l"},
+		},
+		{
+			name:      "key value loop",
+			template:  "{% for key, value in settings %}{{ key }}={{ value.enabled }}{% endfor %}",
+			variables: []string{"settings"},
+			paths:     []string{"settings", "settings{}", "settings{}.enabled"},
+			loopVars:  []string{"key", "value"},
+		},
+		{
+			name:      "conditions",
+			template:  `{% if not draft and role in "admin,owner" %}{{ body }}{% elif score > limit %}x{% endif %}`,
+			variables: []string{"draft", "role", "body", "score", "limit"},
+			paths:     []string{"draft", "role", "body", "score", "limit"},
+		},
+		{
+			name:      "with and set aliases",
+			template:  "{% with c=customer.contact %}{{ c.email }}{% endwith %}{% set total = order.total %}{{ total }}",
+			variables: []string{"customer", "order"},
+			paths:     []string{"customer.contact", "customer.contact.email", "order.total"},
+		},
+		{
+			name:      "macros",
+			template:  `{% macro greet(person, greeting="Hi") %}{{ greeting }} {{ person }}{% endmacro %}{{ greet(user) }}`,
+			variables: []string{"user"},
+			paths:     []string{"user"},
+		},
+		{
+			name:      "index access",
+			template:  "{{ rows.0.name }}",
+			variables: []string{"rows"},
+			paths:     []string{"rows[].name"},
+		},
+		{
+			name:     "literals, comments and nil",
+			template: `{{ "text" }} {{ 42 }} {# {{ hidden }} #}{% comment %}{{ also_hidden }}{% endcomment %}{{ nil }}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis, err := analyzeTemplate(tt.template)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.variables, analysis.Variables, "variables")
+			assert.Equal(t, tt.paths, analysis.Paths, "paths")
+			assert.Equal(t, tt.loopVars, analysis.LoopVars, "loop variables")
+			assert.Equal(t, tt.filters, analysis.Filters, "filters")
+		})
+	}
+}
+
+// TestPongo2Internals fails when the linked pongo2 is not the pinned
+// release or its templates no longer have the unexported fields that the
+// analysis reads, instead of the analysis silently finding nothing
+func TestPongo2Internals(t *testing.T) {
+	info, ok := debug.ReadBuildInfo()
+	require.True(t, ok)
+	version := ""
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/flosch/pongo2/v6" {
+			version = dep.Version
+			if dep.Replace != nil {
+				version = dep.Replace.Version
+			}
+		}
+	}
+	require.Equal(t, pongo2Version, version, "template analysis was checked against pongo2 %s", pongo2Version)
+
+	tpl, err := pongo2.FromString("Hi {{ name }}")
+	require.NoError(t, err)
+	tokens, err := templateTokens(tpl)
+	require.NoError(t, err)
+	require.Len(t, tokens, 4)
+	assert.Equal(t, templateToken{Typ: pongo2.TokenIdentifier, Val: "name", Line: 1, Col: 7}, tokens[2])
+
+	_, isString, err := templateOrigin(tpl)
+	require.NoError(t, err)
+	assert.True(t, isString)
+
+	analysis, err := analyzeTemplate("{{ a }}{% for i in b %}{{ i.c }}{% endfor %}")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, analysis.Variables)
+}
+
+func TestAnalyzeTemplateSyntaxError(t *testing.T) {
+	_, err := analyzeTemplate("{% for x in items %}")
+	assert.Error(t, err)
+	assert.Nil(t, extractTemplateVariables("{% for x in items %}"))
+}
+
+func TestExtractForLoopArrays(t *testing.T) {
+	template := "{% for task in tasks %}{{ task }}{% endfor %}{% for o in customer.orders %}{% endfor %}{{ rows.1 }}"
+	assert.Equal(t, []string{"tasks", "rows"}, extractForLoopArrays(template))
+	assert.True(t, isLoopIterator("task", template))
+	assert.False(t, isLoopIterator("tasks", template))
+}
+
+func TestAnalyzeTemplateOptional(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		optional []string
+	}{
+		{
+			name:     "if guards its block",
+			template: "{% if title %}{{ title }}: {% endif %}{{ body }}",
+			optional: []string{"title"},
+		},
+		{
+			name:     "guard covers child paths",
+			template: "{% if hypotheses %}{% for h in hypotheses %}{{ h.text }}{% endfor %}{% endif %}",
+			optional: []string{"hypotheses", "hypotheses[]", "hypotheses[].text"},
+		},
+		{
+			name:     "elif and default filter",
+			template: `{% if a %}x{% elif b %}{{ b.c }}{% endif %}{{ greeting|default:"Hi" }}`,
+			optional: []string{"a", "b", "b.c", "greeting"},
+		},
+		{
+			name:     "else branch",
+			template: "{% if a %}{{ a }}{% else %}{{ b }}{% endif %}{{ c }}",
+			optional: []string{"a", "b"},
+		},
+		{
+			name:     "branches of nested blocks and firstof",
+			template: `{% if a %}{% for i in items %}{% ifequal i.kind "x" %}{{ i.name }}{% endifequal %}{% endfor %}{% endif %}{% firstof b c %}`,
+			optional: []string{"a", "items", "items[]", "items[].kind", "items[].name", "b", "c"},
+		},
+		{
+			name:     "use outside the guard is required",
+			template: "{% if user %}{{ user.name }}{% endif %}{{ user.id }}{% if other %}{% endif %}{{ other }}",
+			optional: []string{"user", "user.name"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis, err := analyzeTemplate(tt.template)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.optional, analysis.Optional)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package pongo2
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// defaultTemplateCacheSize is the number of dynamic templates an activity
+// keeps compiled when templateCacheSize is not set
+const defaultTemplateCacheSize = 64
+
+// templateCache is an LRU cache of templates passed as input, keyed by the
+// SHA-256 hash of their content so repeated templates are parsed once
+type templateCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type templateCacheEntry struct {
+	key      [sha256.Size]byte
+	template *preparedTemplate
+}
+
+func newTemplateCache(size int) *templateCache {
+	return &templateCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// get returns the prepared template for the content, calling prepare and
+// storing its result on a miss. Errors are not cached.
+func (c *templateCache) get(content string, prepare func(string) (*preparedTemplate, error)) (*preparedTemplate, bool, error) {
+	key := sha256.Sum256([]byte(content))
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*templateCacheEntry).template, true, nil
+	}
+	c.mu.Unlock()
+
+	// Parse outside the lock; concurrent misses for the same content may
+	// both parse, and the first one stored wins
+	prepared, err := prepare(content)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*templateCacheEntry).template, false, nil
+	}
+	c.entries[key] = c.order.PushFront(&templateCacheEntry{key: key, template: prepared})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*templateCacheEntry).key)
+	}
+	return prepared, false, nil
+}
+
+// len returns the number of cached templates
+func (c *templateCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,140 @@
+package pongo2
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTemplateCache(2)
+	parsed := 0
+	prepare := func(content string) (*preparedTemplate, error) {
+		parsed++
+		return &preparedTemplate{source: content}, nil
+	}
+
+	for _, content := range []string{"a", "b", "a", "c", "a", "b"} {
+		template, _, err := cache.get(content, prepare)
+		require.NoError(t, err)
+		assert.Equal(t, content, template.source)
+	}
+
+	// "b" was evicted by "c" and parsed again; "a" stayed in use
+	assert.Equal(t, 4, parsed)
+	assert.Equal(t, 2, cache.len())
+}
+
+func TestTemplateCacheDoesNotStoreErrors(t *testing.T) {
+	cache := newTemplateCache(2)
+	_, _, err := cache.get("{% if %}", func(string) (*preparedTemplate, error) {
+		return nil, fmt.Errorf("parse error")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, cache.len())
+}
+
+func TestTemplateInput(t *testing.T) {
+	act, err := New(&MockInitContext{settings: map[string]interface{}{
+		"template":          "Default for {{ name }}",
+		"templateCacheSize": 4,
+	}})
+	require.NoError(t, err)
+
+	eval := func(template string) (string, error) {
+		evalCtx := &MockActivityContext{
+			inputs: map[string]interface{}{
+				"template":          template,
+				"templateVariables": map[string]interface{}{"name": "Ada", "items": []interface{}{"x", "y"}},
+			},
+			outputs: make(map[string]interface{}),
+		}
+		_, err := act.Eval(evalCtx)
+		result, _ := evalCtx.outputs["renderedPrompt"].(string)
+		return result, err
+	}
+
+	result, err := eval("")
+	require.NoError(t, err)
+	assert.Equal(t, "Default for Ada", result)
+
+	dynamic := "Hi {{ name }}:{% for i in items %} {{ i }}{% endfor %}"
+	for i := 0; i < 3; i++ {
+		result, err = eval(dynamic)
+		require.NoError(t, err)
+		assert.Equal(t, "Hi Ada: x y", result)
+	}
+	assert.Equal(t, 1, act.(*Activity).cache.len())
+
+	_, err = eval("{% for i in items %}")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse template input")
+}
+
+func TestTemplateInputWithoutSetting(t *testing.T) {
+	act, err := New(&MockInitContext{settings: map[string]interface{}{}})
+	require.NoError(t, err)
+
+	eval := func(template string) (string, error) {
+		evalCtx := &MockActivityContext{
+			inputs: map[string]interface{}{
+				"template":          template,
+				"templateVariables": map[string]interface{}{"name": "Ada"},
+			},
+			outputs: make(map[string]interface{}),
+		}
+		_, err := act.Eval(evalCtx)
+		result, _ := evalCtx.outputs["renderedPrompt"].(string)
+		return result, err
+	}
+
+	result, err := eval("Hi {{ name }}")
+	require.NoError(t, err)
+	assert.Equal(t, "Hi Ada", result)
+
+	// Without a template setting the input is needed
+	_, err = eval("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "template cannot be empty")
+}
+
+func TestTemplateInputStrictVariables(t *testing.T) {
+	act, err := New(&MockInitContext{settings: map[string]interface{}{
+		"template":        "{{ name }}",
+		"strictVariables": true,
+	}})
+	require.NoError(t, err)
+
+	// The schema of the input template is used for validation
+	evalCtx := &MockActivityContext{
+		inputs: map[string]interface{}{
+			"template":          "{{ name }} {{ topic }}",
+			"templateVariables": map[string]interface{}{"name": "Ada"},
+		},
+		outputs: make(map[string]interface{}),
+	}
+	_, err = act.Eval(evalCtx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "topic: required variable is missing")
+}
+
+// BenchmarkEvalTemplateInput measures Eval with the template passed as input,
+// which hashes the template and reuses the cached compilation
+func BenchmarkEvalTemplateInput(b *testing.B) {
+	act, err := New(&MockInitContext{settings: map[string]interface{}{"template": "unused"}})
+	if err != nil {
+		b.Fatalf("Failed to create activity: %v", err)
+	}
+	ctx := benchmarkContext()
+	ctx.inputs["template"] = benchmarkTemplate
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := act.Eval(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package pongo2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/flosch/pongo2/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// promptFilters are the filters this activity adds to pongo2
+var promptFilters = map[string]pongo2.FilterFunction{
+	"tokencount":      filterTokenCount,
+	"truncate_tokens": filterTruncateTokens,
+	"truncate_middle": filterTruncateMiddle,
+	"tojson":          filterToJSON,
+	"json_pretty":     filterJSONPretty,
+	"yaml":            filterYAML,
+	"bullet_list":     filterBulletList,
+	"numbered_list":   filterNumberedList,
+	"indent":          filterIndent,
+	"dedent":          filterDedent,
+	"xml_escape":      filterXMLEscape,
+	"code_fence":      filterCodeFence,
+	"wrap":            filterWrap,
+	"redact_pii":      filterRedactPII,
+}
+
+var (
+	registerOnce sync.Once
+	// filterConflicts are filters that another package registered first
+	filterConflicts []string
+)
+
+func init() {
+	RegisterFilters()
+}
+
+// RegisterFilters adds the prompt filters and the message and strictvar tags
+// to pongo2's global registry. It is safe to call more than once. pongo2 has
+// a single registry per process, so a name another package registered first
+// keeps that package's filter; New logs those names as a warning.
+func RegisterFilters() {
+	registerOnce.Do(func() {
+		names := make([]string, 0, len(promptFilters))
+		for name := range promptFilters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if pongo2.FilterExists(name) {
+				filterConflicts = append(filterConflicts, name)
+				continue
+			}
+			_ = pongo2.RegisterFilter(name, promptFilters[name])
+		}
+		_ = pongo2.RegisterTag("message", messageTagParser)
+		_ = pongo2.RegisterTag("strictvar", strictVarTagParser)
+	})
+}
+
+func filterError(name string, format string, args ...interface{}) *pongo2.Error {
+	return &pongo2.Error{Sender: "filter:" + name, OrigError: fmt.Errorf(format, args...)}
+}
+
+// filterToJSON implements {{ value|tojson }}. The result is marked safe so
+// autoescaping does not turn quotes into entities.
+func filterToJSON(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	out, err := marshalJSON(in.Interface(), "")
+	if err != nil {
+		return nil, filterError("tojson", "%v", err)
+	}
+	return pongo2.AsSafeValue(out), nil
+}
+
+// filterJSONPretty implements {{ value|json_pretty }} and
+// {{ value|json_pretty:4 }}, indenting by 2 spaces unless a width is given
+func filterJSONPretty(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	width := 2
+	if !param.IsNil() {
+		if !param.IsInteger() || param.Integer() < 0 {
+			return nil, filterError("json_pretty", "json_pretty takes an indent width, e.g. json_pretty:4")
+		}
+		width = param.Integer()
+	}
+	out, err := marshalJSON(in.Interface(), strings.Repeat(" ", width))
+	if err != nil {
+		return nil, filterError("json_pretty", "%v", err)
+	}
+	return pongo2.AsSafeValue(out), nil
+}
+
+// marshalJSON encodes without HTML escaping, which would replace <, > and &
+// in prompts with \u sequences
+func marshalJSON(value interface{}, indent string) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// filterYAML implements {{ value|yaml }}
+func filterYAML(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	out, err := yaml.Marshal(in.Interface())
+	if err != nil {
+		return nil, filterError("yaml", "%v", err)
+	}
+	return pongo2.AsSafeValue(strings.TrimSuffix(string(out), "\n")), nil
+}
+
+// listItems returns the items of a list, the "key: value" pairs of a map in
+// key order, or the value itself as a single item
+func listItems(in *pongo2.Value) []string {
+	if in.IsNil() {
+		return nil
+	}
+	v := reflect.ValueOf(in.Interface())
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, pongo2.AsValue(v.Index(i).Interface()).String())
+		}
+		return items
+	case reflect.Map:
+		items := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			items = append(items, fmt.Sprint(iter.Key().Interface())+": "+pongo2.AsValue(iter.Value().Interface()).String())
+		}
+		sort.Strings(items)
+		return items
+	default:
+		if in.String() == "" {
+			return nil
+		}
+		return []string{in.String()}
+	}
+}
+
+// filterBulletList implements {{ items|bullet_list }} and
+// {{ items|bullet_list:"*" }}
+func filterBulletList(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	bullet := "-"
+	if !param.IsNil() {
+		bullet = param.String()
+	}
+	items := listItems(in)
+	for i, item := range items {
+		items[i] = bullet + " " + item
+	}
+	return pongo2.AsValue(strings.Join(items, "\n")), nil
+}
+
+// filterNumberedList implements {{ items|numbered_list }} and
+// {{ items|numbered_list:0 }}, numbering from 1 unless a start is given
+func filterNumberedList(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	start := 1
+	if !param.IsNil() {
+		if !param.IsInteger() {
+			return nil, filterError("numbered_list", "numbered_list takes a start number, e.g. numbered_list:0")
+		}
+		start = param.Integer()
+	}
+	items := listItems(in)
+	for i, item := range items {
+		items[i] = fmt.Sprintf("%d. %s", start+i, item)
+	}
+	return pongo2.AsValue(strings.Join(items, "\n")), nil
+}
+
+// filterIndent implements {{ text|indent }}, {{ text|indent:2 }} and
+// {{ text|indent:"> " }}. Every non-blank line is prefixed with the given
+// string or number of spaces, 4 by default.
+func filterIndent(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	prefix := "    "
+	switch {
+	case param.IsNil():
+	case param.IsInteger():
+		if param.Integer() < 0 {
+			return nil, filterError("indent", "indent width cannot be negative")
+		}
+		prefix = strings.Repeat(" ", param.Integer())
+	default:
+		prefix = param.String()
+	}
+
+	lines := strings.Split(in.String(), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return pongo2.AsValue(strings.Join(lines, "\n")), nil
+}
+
+// filterDedent implements {{ text|dedent }}, removing the leading
+// whitespace all non-blank lines share, like Python's textwrap.dedent
+func filterDedent(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	lines := strings.Split(in.String(), "\n")
+
+	var margin string
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeftFunc(line, unicode.IsSpace))]
+		if first {
+			margin, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, margin) {
+			margin = margin[:len(margin)-1]
+		}
+	}
+
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = line[len(margin):]
+		}
+	}
+	return pongo2.AsValue(strings.Join(lines, "\n")), nil
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+// filterXMLEscape implements {{ text|xml_escape }} for text placed in XML
+// tags of a prompt. The result is marked safe so it is not escaped twice.
+func filterXMLEscape(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return pongo2.AsSafeValue(xmlEscaper.Replace(in.String())), nil
+}
+
+// filterCodeFence implements {{ code|code_fence }} and
+// {{ code|code_fence:"python" }}. The fence is made longer than any run of
+// backticks in the code so the block cannot be closed early.
+func filterCodeFence(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	code := strings.Trim(in.String(), "\n")
+	lang := ""
+	if !param.IsNil() {
+		lang = param.String()
+	}
+
+	longest, run := 0, 0
+	for _, r := range code {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", 3)
+	if longest >= 3 {
+		fence = strings.Repeat("`", longest+1)
+	}
+	return pongo2.AsSafeValue(fence + lang + "\n" + code + "\n" + fence), nil
+}
+
+// filterWrap implements {{ text|wrap }} and {{ text|wrap:60 }}, breaking
+// lines between words at 80 columns unless a width is given. Existing line
+// breaks are kept and words longer than the width are not split.
+func filterWrap(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	width := 80
+	if !param.IsNil() {
+		if !param.IsInteger() || param.Integer() < 1 {
+			return nil, filterError("wrap", "wrap takes a line width, e.g. wrap:60")
+		}
+		width = param.Integer()
+	}
+
+	lines := strings.Split(in.String(), "\n")
+	for i, line := range lines {
+		var wrapped strings.Builder
+		length := 0
+		for _, word := range strings.Fields(line) {
+			wordLength := len([]rune(word))
+			switch {
+			case length == 0:
+			case length+1+wordLength > width:
+				wrapped.WriteString("\n")
+				length = 0
+			default:
+				wrapped.WriteString(" ")
+				length++
+			}
+			wrapped.WriteString(word)
+			length += wordLength
+		}
+		lines[i] = wrapped.String()
+	}
+	return pongo2.AsValue(strings.Join(lines, "\n")), nil
+}
+
+// piiPatterns are matched in order, so card numbers are replaced before
+// the shorter phone numbers can match parts of them
+var piiPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+	valid       func(string) bool
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]", nil},
+	{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[CREDIT_CARD]", luhnValid},
+	{regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), "[SSN]", nil},
+	{regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`), "[IP_ADDRESS]", nil},
+	// Phone numbers start with a country code or have their groups
+	// separated, so IDs and timestamps that are plain digit runs are kept
+	{regexp.MustCompile(`(?:\+\d{1,3}[ .-]?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,3}|(?:\(\d{2,4}\)[ .-]?|\b\d{2,4}[ .-])\d{3,4}[ .-]\d{3,4})\b`), "[PHONE]", nil},
+}
+
+// luhnValid checks the Luhn checksum of a card number, ignoring separators
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// filterRedactPII implements {{ text|redact_pii }}, replacing email
+// addresses, credit card numbers, US social security numbers, IPv4
+// addresses and phone numbers with placeholders such as [EMAIL]. It is
+// pattern based and catches common formats, not every form of PII.
+func filterRedactPII(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	text := in.String()
+	for _, pii := range piiPatterns {
+		text = pii.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if pii.valid != nil && !pii.valid(match) {
+				return match
+			}
+			return pii.replacement
+		})
+	}
+	return pongo2.AsValue(text), nil
+}
@@ -0,0 +1,112 @@
+package pongo2
+
+import (
+	"testing"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func renderFilter(t *testing.T, template string, context pongo2.Context) (string, error) {
+	t.Helper()
+	tpl, err := pongo2.FromString(template)
+	require.NoError(t, err)
+	return tpl.Execute(context)
+}
+
+func TestPromptFilters(t *testing.T) {
+	context := pongo2.Context{
+		"user":   map[string]interface{}{"name": "Ada <admin>", "tags": []interface{}{"a", "b"}},
+		"items":  []interface{}{"first", "second & third"},
+		"limits": map[string]interface{}{"memory": "2Gi", "cpu": 2},
+		"text":   "line one\n\nline two",
+		"code":   "    def f():\n        return 1\n",
+		"quoted": "print(\"```\")",
+		"prose":  "The quick brown fox jumps over the lazy dog\nSecond paragraph here",
+		"pii":    "Mail ada@example.com or call +1 (555) 123-4567. Card 4111 1111 1111 1111, SSN 123-45-6789, host 10.0.0.12, order #98765.",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"tojson", "{{ user|tojson }}", `{"name":"Ada <admin>","tags":["a","b"]}`},
+		{"tojson string", "{{ text|tojson }}", `"line one\n\nline two"`},
+		{"json_pretty", "{{ items|json_pretty }}", "[\n  \"first\",\n  \"second & third\"\n]"},
+		{"json_pretty width", "{{ limits|json_pretty:4 }}", "{\n    \"cpu\": 2,\n    \"memory\": \"2Gi\"\n}"},
+		{"yaml", "{{ user|yaml }}", "name: Ada <admin>\ntags:\n    - a\n    - b"},
+		{"bullet_list", "{{ items|bullet_list }}", "- first\n- second &amp; third"},
+		{"bullet_list marker", `{{ items|bullet_list:"*" }}`, "* first\n* second &amp; third"},
+		{"bullet_list map", "{{ limits|bullet_list }}", "- cpu: 2\n- memory: 2Gi"},
+		{"bullet_list scalar", "{{ text|bullet_list }}", "- line one\n\nline two"},
+		{"bullet_list empty", "{{ missing|bullet_list }}", ""},
+		{"numbered_list", "{{ items|numbered_list }}", "1. first\n2. second &amp; third"},
+		{"numbered_list start", "{{ items|numbered_list:0 }}", "0. first\n1. second &amp; third"},
+		{"indent", "{{ text|indent }}", "    line one\n\n    line two"},
+		{"indent width", "{{ text|indent:2 }}", "  line one\n\n  line two"},
+		{"indent prefix", `{{ text|indent:"> " }}`, "&gt; line one\n\n&gt; line two"},
+		{"dedent", "{{ code|dedent }}", "def f():\n    return 1\n"},
+		{"xml_escape", "<name>{{ user.name|xml_escape }}</name>", "<name>Ada &lt;admin&gt;</name>"},
+		{"code_fence", `{{ code|dedent|code_fence:"python" }}`, "```python\ndef f():\n    return 1\n```"},
+		{"code_fence longer fence", "{{ quoted|code_fence }}", "````\nprint(\"```\")\n````"},
+		{"wrap", "{{ prose|wrap:20 }}", "The quick brown fox\njumps over the lazy\ndog\nSecond paragraph\nhere"},
+		{"redact_pii", "{{ pii|redact_pii }}", "Mail [EMAIL] or call [PHONE]. Card [CREDIT_CARD], SSN [SSN], host [IP_ADDRESS], order #98765."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := renderFilter(t, tt.template, context)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+		})
+	}
+}
+
+func TestRedactPIIPhoneNumbers(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"call +1 (555) 123-4567 today", "call [PHONE] today"},
+		{"call +44 20 7946 0958", "call [PHONE]"},
+		{"call +491511234567", "call [PHONE]"},
+		{"call (555) 123-4567", "call [PHONE]"},
+		{"call 555.123.4567", "call [PHONE]"},
+		{"call 020 7946 0958", "call [PHONE]"},
+		// Digit runs without a country code or separators are not phone numbers
+		{"order 1234567890 shipped", "order 1234567890 shipped"},
+		{"created at 1705316200", "created at 1705316200"},
+		{"invoice 987654321012", "invoice 987654321012"},
+		{"due 2024-01-15", "due 2024-01-15"},
+	}
+
+	for _, tt := range tests {
+		out, err := renderFilter(t, "{{ text|redact_pii }}", pongo2.Context{"text": tt.text})
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, out, tt.text)
+	}
+}
+
+func TestPromptFilterParameterErrors(t *testing.T) {
+	for _, template := range []string{
+		`{{ items|json_pretty:"x" }}`,
+		`{{ items|numbered_list:"a" }}`,
+		"{{ text|indent:negative }}",
+		"{{ text|wrap:0 }}",
+	} {
+		_, err := renderFilter(t, template, pongo2.Context{"items": []interface{}{1}, "text": "x", "negative": -1})
+		assert.Error(t, err, template)
+	}
+}
+
+func TestRegisterFiltersIsIdempotent(t *testing.T) {
+	RegisterFilters()
+	RegisterFilters()
+
+	for name := range promptFilters {
+		assert.True(t, pongo2.FilterExists(name), name)
+	}
+	assert.Empty(t, filterConflicts)
+}
@@ -4,16 +4,25 @@ go 1.21
 
 require (
 	github.com/flosch/pongo2/v6 v6.0.0
+	github.com/pkoukk/tiktoken-go v0.1.8
+	github.com/pkoukk/tiktoken-go-loader v0.0.2
 	github.com/project-flogo/core v1.6.9
-	github.com/stretchr/testify v1.4.0
+	github.com/stretchr/testify v1.8.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/araddon/dateparse v0.0.0-20190622164848-0fb0a474d195 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dlclark/regexp2 v1.10.0 // indirect
+	github.com/google/uuid v1.3.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	go.uber.org/atomic v1.6.0 // indirect
 	go.uber.org/multierr v1.5.0 // indirect
 	go.uber.org/zap v1.16.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
+
+// Template analysis reads unexported fields of pongo2 templates, so pongo2 is
+// pinned to the release they were checked against (pongo2Version in
+// analysis.go). Run TestPongo2Internals before changing it.
+replace github.com/flosch/pongo2/v6 => github.com/flosch/pongo2/v6 v6.0.0
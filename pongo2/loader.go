@@ -0,0 +1,321 @@
+package pongo2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// Template sources
+const (
+	templateSourceInline    = "inline"
+	templateSourceFile      = "file"
+	templateSourceDirectory = "directory"
+)
+
+// Template loaders
+const (
+	templateLoaderFilesystem = "filesystem"
+	templateLoaderEmbedded   = "embedded"
+)
+
+var (
+	templateFSMu sync.RWMutex
+	templateFS   fs.FS
+)
+
+// RegisterTemplateFS sets the file system read by the embedded template
+// loader, typically an embed.FS compiled into the application:
+//
+//	//go:embed prompts
+//	var prompts embed.FS
+//
+//	func init() { pongo2.RegisterTemplateFS(prompts) }
+func RegisterTemplateFS(fsys fs.FS) {
+	templateFSMu.Lock()
+	defer templateFSMu.Unlock()
+	templateFS = fsys
+}
+
+func registeredTemplateFS() fs.FS {
+	templateFSMu.RLock()
+	defer templateFSMu.RUnlock()
+	return templateFS
+}
+
+// fsLoader loads templates from an fs.FS. Names resolve from the root of
+// the file system, like LocalFilesystemLoader with a base directory.
+type fsLoader struct {
+	fsys fs.FS
+}
+
+func (l *fsLoader) Abs(base, name string) string {
+	return path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/"))
+}
+
+func (l *fsLoader) Get(name string) (io.Reader, error) {
+	buf, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// dirLoader restricts a LocalFilesystemLoader to its base directory so a
+// prompt library cannot include files from outside it
+type dirLoader struct {
+	*pongo2.LocalFilesystemLoader
+	dir string
+}
+
+func (l *dirLoader) Get(name string) (io.Reader, error) {
+	rel, err := filepath.Rel(l.dir, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("template '%s' is outside the template directory '%s'", name, l.dir)
+	}
+	return l.LocalFilesystemLoader.Get(name)
+}
+
+// newTemplateLoader creates the loader that resolves the template and its
+// extends, include and import tags
+func newTemplateLoader(s *Settings) (pongo2.TemplateLoader, error) {
+	switch s.TemplateLoader {
+	case "", templateLoaderFilesystem:
+		if s.TemplateSource == templateSourceDirectory {
+			dir, err := filepath.Abs(s.TemplateBaseDir)
+			if err != nil {
+				return nil, err
+			}
+			local, err := pongo2.NewLocalFileSystemLoader(dir)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template directory: %w", err)
+			}
+			return &dirLoader{LocalFilesystemLoader: local, dir: dir}, nil
+		}
+		local, err := pongo2.NewLocalFileSystemLoader(s.TemplateBaseDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template base directory: %w", err)
+		}
+		return local, nil
+	case templateLoaderEmbedded:
+		fsys, err := embeddedTemplateFS(s)
+		if err != nil {
+			return nil, err
+		}
+		return &fsLoader{fsys: fsys}, nil
+	default:
+		return nil, fmt.Errorf("invalid templateLoader '%s': must be '%s' or '%s'", s.TemplateLoader, templateLoaderFilesystem, templateLoaderEmbedded)
+	}
+}
+
+// embeddedTemplateFS returns the registered file system, or its
+// templateBaseDir directory when one is set
+func embeddedTemplateFS(s *Settings) (fs.FS, error) {
+	fsys := registeredTemplateFS()
+	if fsys == nil {
+		return nil, fmt.Errorf("embedded template loader requires a file system registered with RegisterTemplateFS")
+	}
+	if s.TemplateBaseDir != "" {
+		sub, err := fs.Sub(fsys, path.Clean(filepath.ToSlash(s.TemplateBaseDir)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid template base directory: %w", err)
+		}
+		fsys = sub
+	}
+	return fsys, nil
+}
+
+// inputLoader loads the templates that a template input extends, includes
+// or imports. Names resolve from the template directory and must be
+// relative without . or .. elements, so an input cannot read other files.
+type inputLoader struct {
+	fsLoader
+}
+
+func (l *inputLoader) Abs(base, name string) string {
+	return filepath.ToSlash(name)
+}
+
+func (l *inputLoader) Get(name string) (io.Reader, error) {
+	if l.fsys == nil || !fs.ValidPath(name) {
+		return nil, fmt.Errorf("template input cannot load '%s': names must be relative to the template directory", name)
+	}
+	return l.fsLoader.Get(name)
+}
+
+// newInputSet creates the template set that compiles template inputs. The
+// input comes from the flow rather than the app developer, so it cannot read
+// files: ssi is banned, and extends, include and import are confined to the
+// template directory, or banned when no templateBaseDir is set.
+func newInputSet(s *Settings) (*templateSet, error) {
+	var fsys fs.FS
+	switch s.TemplateLoader {
+	case templateLoaderEmbedded:
+		var err error
+		if fsys, err = embeddedTemplateFS(s); err != nil {
+			return nil, err
+		}
+	default:
+		if s.TemplateBaseDir != "" {
+			dir, err := filepath.Abs(s.TemplateBaseDir)
+			if err != nil {
+				return nil, err
+			}
+			fsys = os.DirFS(dir)
+		}
+	}
+
+	banned := []string{"ssi"}
+	if fsys == nil {
+		banned = append(banned, "extends", "include", "import")
+	}
+	return newTemplateSet("pongo2-prompt-input", &inputLoader{fsLoader{fsys: fsys}}, s.StrictUndefined, banned...)
+}
+
+// templateSet is the template set of an activity together with the loader
+// that resolves names in it
+type templateSet struct {
+	*pongo2.TemplateSet
+	loader pongo2.TemplateLoader
+	// plain compiles the unchanged templates in strict undefined mode,
+	// nil otherwise
+	plain *pongo2.TemplateSet
+}
+
+// newTemplateSet creates a template set resolving names through loader,
+// rewriting templates in strict undefined mode, with the given tags banned
+func newTemplateSet(name string, loader pongo2.TemplateLoader, strict bool, banned ...string) (*templateSet, error) {
+	set := &templateSet{TemplateSet: pongo2.NewSet(name, loader), loader: loader}
+	if strict {
+		set.plain = pongo2.NewSet(name+"-plain", loader)
+		set.TemplateSet = pongo2.NewSet(name, &strictLoader{TemplateLoader: loader, plain: set.plain})
+	}
+	for _, tag := range banned {
+		if err := set.BanTag(tag); err != nil {
+			return nil, err
+		}
+		if set.plain != nil {
+			if err := set.plain.BanTag(tag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// loadTemplate compiles the template named by the settings in a template
+// set of its own, so extends, include and import resolve through the
+// configured loader. An empty inline template returns no template; the
+// template then has to come from the template input.
+func loadTemplate(s *Settings) (*templateSet, *pongo2.Template, error) {
+	switch s.TemplateSource {
+	case "", templateSourceInline:
+	case templateSourceFile:
+		if s.TemplatePath == "" {
+			return nil, nil, fmt.Errorf("templatePath is required for template source '%s'", s.TemplateSource)
+		}
+	case templateSourceDirectory:
+		if s.TemplateBaseDir == "" || s.TemplatePath == "" {
+			return nil, nil, fmt.Errorf("templateBaseDir and templatePath are required for template source '%s'", s.TemplateSource)
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid templateSource '%s': must be '%s', '%s' or '%s'", s.TemplateSource,
+			templateSourceInline, templateSourceFile, templateSourceDirectory)
+	}
+
+	loader, err := newTemplateLoader(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	set, err := newTemplateSet("pongo2-prompt", loader, s.StrictUndefined)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var compiled *pongo2.Template
+	switch {
+	case s.TemplateSource == templateSourceFile || s.TemplateSource == templateSourceDirectory:
+		compiled, err = set.FromFile(s.TemplatePath)
+	case s.Template != "":
+		compiled, err = set.fromString(s.Template)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return set, compiled, nil
+}
+
+// analyze analyzes a compiled template together with the templates it
+// extends, includes or imports by name, so variables of shared layouts and
+// partials are part of the schema
+func (set *templateSet) analyze(compiled *pongo2.Template) (*templateAnalysis, error) {
+	var tokens []templateToken
+	seen := make(map[string]bool)
+
+	var collect func(tpl *pongo2.Template) error
+	collect = func(tpl *pongo2.Template) error {
+		own, err := templateTokens(tpl)
+		if err != nil {
+			return err
+		}
+		tokens = append(tokens, own...)
+
+		for _, ref := range templateReferences(own) {
+			name := ref
+			origin, isString, err := templateOrigin(tpl)
+			if err != nil {
+				return err
+			}
+			if !isString {
+				name = set.loader.Abs(origin, ref)
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			referenced, err := set.FromCache(name)
+			if err != nil {
+				return err
+			}
+			if err := collect(referenced); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := collect(compiled); err != nil {
+		return nil, err
+	}
+	return analyzeTokens(tokens), nil
+}
+
+// templateOrigin reads the name of a template and whether it was compiled
+// from a string, both unexported; pongo2 resolves the names of referenced
+// templates relative to them
+func templateOrigin(tpl *pongo2.Template) (string, bool, error) {
+	template := reflect.ValueOf(tpl).Elem()
+	name := template.FieldByName("name")
+	isString := template.FieldByName("isTplString")
+	if !name.IsValid() || name.Kind() != reflect.String || !isString.IsValid() || isString.Kind() != reflect.Bool {
+		return "", false, fmt.Errorf("name of pongo2 template is not available; template analysis supports pongo2 %s", pongo2Version)
+	}
+	return name.String(), isString.Bool(), nil
+}
+
+// templateDescription names the template in log messages
+func templateDescription(s *Settings) string {
+	if s.TemplateSource == templateSourceFile || s.TemplateSource == templateSourceDirectory {
+		return s.TemplatePath
+	}
+	return s.Template
+}
@@ -0,0 +1,256 @@
+package pongo2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// promptLibrary writes a layout, a partial and a macro file to a directory
+func promptLibrary(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"layouts/base.txt":    "You are {{ role }}.\n{% block task %}{% endblock %}\n{% include \"partials/footer.txt\" %}",
+		"partials/footer.txt": "Answer in {{ language }}.",
+		"macros.txt":          "{% macro item(text) export %}- {{ text }}{% endmacro %}",
+		"review.txt": `{% extends "layouts/base.txt" %}` +
+			`{% block task %}{% import "macros.txt" item %}Review:{% for f in files %}
+{{ item(f.name) }}{% endfor %}{% endblock %}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func renderWith(t *testing.T, settings map[string]interface{}, variables map[string]interface{}) string {
+	act, err := New(&MockInitContext{settings: settings})
+	require.NoError(t, err)
+
+	evalCtx := &MockActivityContext{
+		inputs:  map[string]interface{}{"templateVariables": variables},
+		outputs: make(map[string]interface{}),
+	}
+	_, err = act.Eval(evalCtx)
+	require.NoError(t, err)
+	return evalCtx.outputs["renderedPrompt"].(string)
+}
+
+var reviewVariables = map[string]interface{}{
+	"role":     "a code reviewer",
+	"language": "English",
+	"files":    []interface{}{map[string]interface{}{"name": "main.go"}},
+}
+
+const reviewPrompt = "You are a code reviewer.\nReview:\n- main.go\nAnswer in English."
+
+func TestTemplateSourceDirectory(t *testing.T) {
+	dir := promptLibrary(t)
+
+	result := renderWith(t, map[string]interface{}{
+		"templateSource":  "directory",
+		"templateBaseDir": dir,
+		"templatePath":    "review.txt",
+	}, reviewVariables)
+	assert.Equal(t, reviewPrompt, result)
+}
+
+func TestTemplateSourceFile(t *testing.T) {
+	dir := promptLibrary(t)
+
+	// Without a base directory names resolve relative to the including file
+	result := renderWith(t, map[string]interface{}{
+		"templateSource": "file",
+		"templatePath":   filepath.Join(dir, "partials", "footer.txt"),
+	}, reviewVariables)
+	assert.Equal(t, "Answer in English.", result)
+
+	result = renderWith(t, map[string]interface{}{
+		"templateSource":  "file",
+		"templatePath":    filepath.Join(dir, "review.txt"),
+		"templateBaseDir": dir,
+	}, reviewVariables)
+	assert.Equal(t, reviewPrompt, result)
+}
+
+func TestInlineTemplateWithBaseDir(t *testing.T) {
+	dir := promptLibrary(t)
+
+	result := renderWith(t, map[string]interface{}{
+		"template":        `{% extends "layouts/base.txt" %}{% block task %}Summarize {{ topic }}.{% endblock %}`,
+		"templateBaseDir": dir,
+	}, map[string]interface{}{"role": "an analyst", "language": "French", "topic": "sales"})
+	assert.Equal(t, "You are an analyst.\nSummarize sales.\nAnswer in French.", result)
+}
+
+func TestEmbeddedTemplateLoader(t *testing.T) {
+	RegisterTemplateFS(fstest.MapFS{
+		"prompts/base.txt":  {Data: []byte("System: {% block body %}{% endblock %}")},
+		"prompts/greet.txt": {Data: []byte(`{% extends "base.txt" %}{% block body %}Hello {{ name }}{% endblock %}`)},
+	})
+	defer RegisterTemplateFS(nil)
+
+	result := renderWith(t, map[string]interface{}{
+		"templateSource":  "directory",
+		"templateLoader":  "embedded",
+		"templateBaseDir": "prompts",
+		"templatePath":    "greet.txt",
+	}, map[string]interface{}{"name": "Ada"})
+	assert.Equal(t, "System: Hello Ada", result)
+}
+
+func TestTemplateSetSchema(t *testing.T) {
+	dir := promptLibrary(t)
+
+	provider := &TemplateSchemaProvider{}
+	schema, err := provider.GetInputSchema(map[string]interface{}{
+		"templateSource":  "directory",
+		"templateBaseDir": dir,
+		"templatePath":    "review.txt",
+	})
+	require.NoError(t, err)
+
+	// Variables of the layout and the included footer are part of the schema
+	properties := schema["properties"].(map[string]interface{})
+	assert.Contains(t, properties, "role")
+	assert.Contains(t, properties, "language")
+	assert.Contains(t, properties, "files")
+	assert.NotContains(t, properties, "text")
+	assert.NotContains(t, properties, "item")
+}
+
+func TestTemplateOrigin(t *testing.T) {
+	dir := promptLibrary(t)
+	set := pongo2.NewSet("origin", pongo2.MustNewLocalFileSystemLoader(dir))
+
+	tpl, err := set.FromFile("review.txt")
+	require.NoError(t, err)
+	name, isString, err := templateOrigin(tpl)
+	require.NoError(t, err)
+	assert.Equal(t, "review.txt", name)
+	assert.False(t, isString)
+
+	tpl, err = set.FromString("{{ role }}")
+	require.NoError(t, err)
+	_, isString, err = templateOrigin(tpl)
+	require.NoError(t, err)
+	assert.True(t, isString)
+}
+
+func TestTemplateInputSandbox(t *testing.T) {
+	dir := promptLibrary(t)
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "leak.txt"), []byte(`{% include "`+outside+`" %}`), 0o644))
+
+	eval := func(settings map[string]interface{}, template string) (string, error) {
+		act, err := New(&MockInitContext{settings: settings})
+		require.NoError(t, err)
+		evalCtx := &MockActivityContext{
+			inputs: map[string]interface{}{
+				"template":          template,
+				"templateVariables": map[string]interface{}{"language": "English"},
+			},
+			outputs: make(map[string]interface{}),
+		}
+		_, err = act.Eval(evalCtx)
+		result, _ := evalCtx.outputs["renderedPrompt"].(string)
+		return result, err
+	}
+
+	// Templates in the prompt library can be included by a relative name
+	library := map[string]interface{}{"templateBaseDir": dir}
+	result, err := eval(library, `{% include "partials/footer.txt" %}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Answer in English.", result)
+
+	for _, template := range []string{
+		`{% ssi "` + outside + `" %}`,
+		`{% include "` + outside + `" %}`,
+		`{% include "../` + filepath.Base(filepath.Dir(outside)) + `/secret.txt" %}`,
+		`{% extends "partials/../../secret.txt" %}`,
+		`{% include "leak.txt" %}`,
+	} {
+		result, err := eval(library, template)
+		assert.Error(t, err, template)
+		assert.NotContains(t, result, "secret", template)
+	}
+
+	// Without a prompt library nothing can be loaded
+	for _, template := range []string{
+		`{% ssi "` + outside + `" %}`,
+		`{% include "` + outside + `" %}`,
+		`{% import "macros.txt" item %}`,
+	} {
+		_, err := eval(map[string]interface{}{}, template)
+		require.Error(t, err, template)
+		assert.Contains(t, err.Error(), "is not allowed", template)
+	}
+
+	// The template setting is trusted and keeps the unrestricted loader
+	result, err = eval(map[string]interface{}{"template": `{% include "` + outside + `" %}`}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", result)
+}
+
+func TestTemplateSourceErrors(t *testing.T) {
+	dir := promptLibrary(t)
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o644))
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		err      string
+	}{
+		{
+			name:     "unknown source",
+			settings: map[string]interface{}{"templateSource": "url", "template": "x"},
+			err:      "invalid templateSource 'url'",
+		},
+		{
+			name:     "directory without base dir",
+			settings: map[string]interface{}{"templateSource": "directory", "templatePath": "review.txt"},
+			err:      "templateBaseDir and templatePath are required",
+		},
+		{
+			name:     "missing file",
+			settings: map[string]interface{}{"templateSource": "file", "templatePath": filepath.Join(dir, "missing.txt")},
+			err:      "failed to parse template",
+		},
+		{
+			name: "include outside the directory",
+			settings: map[string]interface{}{
+				"templateSource":  "directory",
+				"templateBaseDir": dir,
+				"templatePath":    outside,
+			},
+			err: "failed to parse template",
+		},
+		{
+			name:     "unknown loader",
+			settings: map[string]interface{}{"template": "x", "templateLoader": "http"},
+			err:      "invalid templateLoader 'http'",
+		},
+		{
+			name:     "embedded loader without file system",
+			settings: map[string]interface{}{"template": "x", "templateLoader": "embedded"},
+			err:      "requires a file system registered with RegisterTemplateFS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&MockInitContext{settings: tt.settings})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package pongo2
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// Message formats
+const (
+	messageFormatOpenAI    = "openai"
+	messageFormatAnthropic = "anthropic"
+)
+
+// Message roles
+const (
+	roleSystem    = "system"
+	roleUser      = "user"
+	roleAssistant = "assistant"
+)
+
+// The message tag marks its rendered body with characters from the Unicode
+// private use area, which prompts do not contain, so messages survive
+// loops, includes and template inheritance and are split after rendering:
+// messageStart + role + messageRoleEnd + content + messageEnd
+const (
+	messageStart   = "\ue000"
+	messageRoleEnd = "\ue001"
+	messageEnd     = "\ue002"
+)
+
+// messageMarkers removes the marker characters from template variables,
+// so a value cannot open or close a message
+var messageMarkers = strings.NewReplacer(messageStart, "", messageRoleEnd, "", messageEnd, "")
+
+// withoutMessageMarkers returns a template variable with the message
+// markers removed from its strings, including map keys and the items of
+// JSON arrays and objects. Values without markers are returned as they are,
+// so only variables that contain one are copied.
+func withoutMessageMarkers(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if strings.ContainsAny(v, messageStart+messageRoleEnd+messageEnd) {
+			return messageMarkers.Replace(v), true
+		}
+	case []string:
+		var copied []string
+		for i, item := range v {
+			if clean, changed := withoutMessageMarkers(item); changed {
+				if copied == nil {
+					copied = append([]string(nil), v...)
+				}
+				copied[i] = clean.(string)
+			}
+		}
+		if copied != nil {
+			return copied, true
+		}
+	case []interface{}:
+		var copied []interface{}
+		for i, item := range v {
+			if clean, changed := withoutMessageMarkers(item); changed {
+				if copied == nil {
+					copied = append([]interface{}(nil), v...)
+				}
+				copied[i] = clean
+			}
+		}
+		if copied != nil {
+			return copied, true
+		}
+	case map[string]interface{}:
+		var copied map[string]interface{}
+		for key, item := range v {
+			cleanKey, keyChanged := withoutMessageMarkers(key)
+			clean, changed := withoutMessageMarkers(item)
+			if !keyChanged && !changed {
+				continue
+			}
+			if copied == nil {
+				copied = make(map[string]interface{}, len(v))
+				for k, i := range v {
+					copied[k] = i
+				}
+			}
+			delete(copied, key)
+			copied[cleanKey.(string)] = clean
+		}
+		if copied != nil {
+			return copied, true
+		}
+	}
+	return value, false
+}
+
+// messageTagNode renders {% message role="system" %}...{% endmessage %}
+type messageTagNode struct {
+	role    pongo2.IEvaluator
+	wrapper *pongo2.NodeWrapper
+}
+
+func (node *messageTagNode) Execute(ctx *pongo2.ExecutionContext, writer pongo2.TemplateWriter) *pongo2.Error {
+	role, err := node.role.Evaluate(ctx)
+	if err != nil {
+		return err
+	}
+	switch role.String() {
+	case roleSystem, roleUser, roleAssistant:
+	default:
+		return ctx.Error(fmt.Sprintf("message role must be '%s', '%s' or '%s', got '%s'",
+			roleSystem, roleUser, roleAssistant, role.String()), node.role.GetPositionToken())
+	}
+
+	var body bytes.Buffer
+	if err := node.wrapper.Execute(ctx, &body); err != nil {
+		return err
+	}
+	if strings.Contains(body.String(), messageStart) {
+		return ctx.Error("message tags cannot be nested", node.role.GetPositionToken())
+	}
+
+	writer.WriteString(messageStart + role.String() + messageRoleEnd)
+	writer.Write(body.Bytes())
+	writer.WriteString(messageEnd)
+	return nil
+}
+
+func messageTagParser(doc *pongo2.Parser, start *pongo2.Token, arguments *pongo2.Parser) (pongo2.INodeTag, *pongo2.Error) {
+	node := &messageTagNode{}
+
+	if arguments.Match(pongo2.TokenIdentifier, "role") == nil || arguments.Match(pongo2.TokenSymbol, "=") == nil {
+		return nil, arguments.Error("message tag requires role=<role>.", nil)
+	}
+	role, err := arguments.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	node.role = role
+	if arguments.Remaining() > 0 {
+		return nil, arguments.Error("Malformed message-tag arguments.", nil)
+	}
+
+	wrapper, _, err := doc.WrapUntilTag("endmessage")
+	if err != nil {
+		return nil, err
+	}
+	node.wrapper = wrapper
+
+	return node, nil
+}
+
+// chatMessage is a message of the messages output
+type chatMessage struct {
+	Role    string
+	Content string
+}
+
+// splitMessages separates the sections marked by message tags from the
+// rendered output. It returns the output without markers, the messages with
+// trimmed content, and the text found outside any message. Messages that
+// render empty are dropped; messages is nil when the template has no
+// message tags. A marker that does not start a complete message, such as
+// one written literally in the template, is kept as text.
+func splitMessages(rendered string) (text string, messages []chatMessage, stray string) {
+	if !strings.Contains(rendered, messageStart) {
+		return rendered, nil, ""
+	}
+
+	tagged := false
+	var out, outside strings.Builder
+	for rest := rendered; rest != ""; {
+		start := strings.Index(rest, messageStart)
+		if start < 0 {
+			out.WriteString(rest)
+			outside.WriteString(rest)
+			break
+		}
+		out.WriteString(rest[:start])
+		outside.WriteString(rest[:start])
+		rest = rest[start+len(messageStart):]
+
+		roleEnd := strings.Index(rest, messageRoleEnd)
+		end := strings.Index(rest, messageEnd)
+		if roleEnd < 0 || end < roleEnd || strings.Contains(rest[:roleEnd], messageStart) {
+			out.WriteString(messageStart)
+			outside.WriteString(messageStart)
+			continue
+		}
+		role, content := rest[:roleEnd], rest[roleEnd+len(messageRoleEnd):end]
+		rest = rest[end+len(messageEnd):]
+
+		if !tagged {
+			tagged, messages = true, []chatMessage{}
+		}
+
+		out.WriteString(content)
+		if content = strings.TrimSpace(content); content != "" {
+			messages = append(messages, chatMessage{Role: role, Content: content})
+		}
+	}
+	return out.String(), messages, strings.TrimSpace(outside.String())
+}
+
+// formatMessages converts messages to the JSON shape of an LLM API. OpenAI
+// keeps system messages in the list. Anthropic takes the system prompt as a
+// separate field and expects user and assistant turns to alternate, so
+// consecutive messages of one role are joined.
+func formatMessages(messages []chatMessage, format string) (list []interface{}, system string) {
+	if format != messageFormatAnthropic {
+		list = make([]interface{}, 0, len(messages))
+		for _, message := range messages {
+			list = append(list, map[string]interface{}{"role": message.Role, "content": message.Content})
+		}
+		return list, ""
+	}
+
+	var systemParts []string
+	var merged []chatMessage
+	for _, message := range messages {
+		switch {
+		case message.Role == roleSystem:
+			systemParts = append(systemParts, message.Content)
+		case len(merged) > 0 && merged[len(merged)-1].Role == message.Role:
+			merged[len(merged)-1].Content += "\n\n" + message.Content
+		default:
+			merged = append(merged, message)
+		}
+	}
+
+	list = make([]interface{}, 0, len(merged))
+	for _, message := range merged {
+		list = append(list, map[string]interface{}{"role": message.Role, "content": message.Content})
+	}
+	return list, strings.Join(systemParts, "\n\n")
+}
@@ -0,0 +1,157 @@
+package pongo2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const chatTemplate = `{% message role="system" %}You are {{ persona }}.{% endmessage %}
+{% for turn in history %}{% message role=turn.role %}{{ turn.text }}{% endmessage %}
+{% endfor %}{% message role="user" %}{{ question }}{% endmessage %}
+{% message role="user" %}{% if hint %}Hint: {{ hint }}{% endif %}{% endmessage %}`
+
+var chatVariables = map[string]interface{}{
+	"persona": "a support agent",
+	"history": []interface{}{
+		map[string]interface{}{"role": "user", "text": "My order is late"},
+		map[string]interface{}{"role": "assistant", "text": "Which order?"},
+		map[string]interface{}{"role": "user", "text": "Order 42"},
+	},
+	"question": "Where is it?",
+}
+
+func evalMessages(t *testing.T, settings map[string]interface{}, variables map[string]interface{}) map[string]interface{} {
+	act, err := New(&MockInitContext{settings: settings})
+	require.NoError(t, err)
+
+	evalCtx := &MockActivityContext{
+		inputs:  map[string]interface{}{"templateVariables": variables},
+		outputs: make(map[string]interface{}),
+	}
+	_, err = act.Eval(evalCtx)
+	require.NoError(t, err)
+	return evalCtx.outputs
+}
+
+func TestMessagesOpenAIFormat(t *testing.T) {
+	outputs := evalMessages(t, map[string]interface{}{"template": chatTemplate}, chatVariables)
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "system", "content": "You are a support agent."},
+		map[string]interface{}{"role": "user", "content": "My order is late"},
+		map[string]interface{}{"role": "assistant", "content": "Which order?"},
+		map[string]interface{}{"role": "user", "content": "Order 42"},
+		map[string]interface{}{"role": "user", "content": "Where is it?"},
+	}, outputs["messages"])
+	assert.Equal(t, "", outputs["system"])
+	assert.Equal(t, "You are a support agent.\nMy order is late\nWhich order?\nOrder 42\nWhere is it?", outputs["renderedPrompt"])
+}
+
+func TestMessagesAnthropicFormat(t *testing.T) {
+	outputs := evalMessages(t, map[string]interface{}{
+		"template":      chatTemplate,
+		"messageFormat": "anthropic",
+	}, chatVariables)
+
+	// The system prompt is separate and consecutive user turns are joined
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "user", "content": "My order is late"},
+		map[string]interface{}{"role": "assistant", "content": "Which order?"},
+		map[string]interface{}{"role": "user", "content": "Order 42\n\nWhere is it?"},
+	}, outputs["messages"])
+	assert.Equal(t, "You are a support agent.", outputs["system"])
+}
+
+func TestMessagesWithoutMessageTags(t *testing.T) {
+	outputs := evalMessages(t, map[string]interface{}{"template": "Summarize {{ topic }}"}, map[string]interface{}{"topic": "sales"})
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"role": "user", "content": "Summarize sales"},
+	}, outputs["messages"])
+}
+
+func TestMessageMarkersInVariables(t *testing.T) {
+	for _, marker := range []string{messageStart, messageRoleEnd, messageEnd} {
+		value := "a" + marker + "b"
+
+		// Without message tags
+		outputs := evalMessages(t, map[string]interface{}{"template": "Hello {{ name }}"}, map[string]interface{}{"name": value})
+		assert.Equal(t, "Hello ab", outputs["renderedPrompt"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"role": "user", "content": "Hello ab"},
+		}, outputs["messages"])
+
+		// Inside a message, in nested values and in map keys
+		outputs = evalMessages(t, map[string]interface{}{
+			"template": `{% message role="user" %}{{ name }} {{ items.0 }}{% for k, v in labels %} {{ k }}={{ v }}{% endfor %}!{% endmessage %}`,
+		}, map[string]interface{}{
+			"name":   value,
+			"items":  []interface{}{value},
+			"labels": map[string]interface{}{value: value},
+		})
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"role": "user", "content": "ab ab ab=ab!"},
+		}, outputs["messages"])
+	}
+}
+
+func TestSplitMessagesIncompleteMarkers(t *testing.T) {
+	for _, rendered := range []string{
+		"a" + messageStart + "x",
+		"a" + messageStart + "user" + messageEnd + "x" + messageRoleEnd,
+		messageStart + "a" + messageStart + "user" + messageRoleEnd + "hi" + messageEnd,
+	} {
+		text, messages, _ := splitMessages(rendered)
+		assert.Contains(t, text, messageStart, rendered)
+		if len(messages) > 0 {
+			assert.Equal(t, []chatMessage{{Role: "user", Content: "hi"}}, messages)
+		}
+	}
+
+	// A literal marker alone does not make the output a list of messages
+	_, messages, _ := splitMessages("a" + messageStart + "x")
+	assert.Nil(t, messages)
+}
+
+func TestMessageTagErrors(t *testing.T) {
+	_, err := New(&MockInitContext{settings: map[string]interface{}{"template": `{% message "user" %}x{% endmessage %}`}})
+	assert.Error(t, err)
+
+	_, err = New(&MockInitContext{settings: map[string]interface{}{"template": "x", "messageFormat": "gemini"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid messageFormat 'gemini'")
+
+	tests := []struct {
+		name     string
+		template string
+		err      string
+	}{
+		{"unknown role", `{% message role=r %}x{% endmessage %}`, "message role must be"},
+		{"nested", `{% message role="user" %}{% message role="user" %}x{% endmessage %}{% endmessage %}`, "cannot be nested"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			act, err := New(&MockInitContext{settings: map[string]interface{}{"template": tt.template}})
+			require.NoError(t, err)
+			evalCtx := &MockActivityContext{
+				inputs:  map[string]interface{}{"templateVariables": map[string]interface{}{"r": "tool"}},
+				outputs: make(map[string]interface{}),
+			}
+			_, err = act.Eval(evalCtx)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestSplitMessages(t *testing.T) {
+	rendered := "intro " + messageStart + "user" + messageRoleEnd + " hi " + messageEnd +
+		messageStart + "assistant" + messageRoleEnd + "  " + messageEnd + " outro"
+
+	text, messages, stray := splitMessages(rendered)
+	assert.Equal(t, "intro  hi    outro", text)
+	assert.Equal(t, []chatMessage{{Role: "user", Content: "hi"}}, messages)
+	assert.Equal(t, "intro  outro", stray)
+}
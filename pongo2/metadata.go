@@ -6,18 +6,29 @@ import (
 
 // Settings for the activity
 type Settings struct {
-	Template string `md:"template,required"`
+	Template          string `md:"template"`
+	TemplateSource    string `md:"templateSource"`  // inline, file or directory
+	TemplatePath      string `md:"templatePath"`    // template file, or template name in a directory
+	TemplateBaseDir   string `md:"templateBaseDir"` // resolves extends, include and import
+	TemplateLoader    string `md:"templateLoader"`  // filesystem or embedded
+	StrictVariables   bool   `md:"strictVariables"`
+	StrictUndefined   bool   `md:"strictUndefined"`   // undefined variables fail the render
+	TemplateCacheSize int    `md:"templateCacheSize"` // compiled templates kept for the template input
+	MessageFormat     string `md:"messageFormat"`     // openai or anthropic
+	TokenBudget       int    `md:"tokenBudget"`       // maximum tokens of the rendered prompt, 0 for no limit
+	CountTokens       bool   `md:"countTokens"`       // set the tokenCount output without a budget
 }
 
 // Input defines the input structure
 type Input struct {
+	Template          string                 `md:"template"` // overrides the template setting
 	TemplateVariables map[string]interface{} `md:"templateVariables"`
 }
 
 // ToMap converts the struct Input into a map
 func (i *Input) ToMap() map[string]interface{} {
 	return map[string]interface{}{
-
+		"template":          i.Template,
 		"templateVariables": i.TemplateVariables,
 	}
 }
@@ -26,6 +37,11 @@ func (i *Input) ToMap() map[string]interface{} {
 func (i *Input) FromMap(values map[string]interface{}) error {
 	var err error
 
+	i.Template, err = coerce.ToString(values["template"])
+	if err != nil {
+		return err
+	}
+
 	i.TemplateVariables, err = coerce.ToObject(values["templateVariables"])
 	if err != nil {
 		return err
@@ -36,13 +52,21 @@ func (i *Input) FromMap(values map[string]interface{}) error {
 
 // Output defines the output structure
 type Output struct {
-	RenderedPrompt string `md:"renderedPrompt"`
+	RenderedPrompt string        `md:"renderedPrompt"`
+	Messages       []interface{} `md:"messages"` // chat messages with role and content
+	System         string        `md:"system"`   // system prompt in the anthropic message format
+	TokenCount     int           `md:"tokenCount"`
+	WithinBudget   bool          `md:"withinBudget"`
 }
 
 // ToMap converts the struct Output into a map
 func (o *Output) ToMap() map[string]interface{} {
 	return map[string]interface{}{
 		"renderedPrompt": o.RenderedPrompt,
+		"messages":       o.Messages,
+		"system":         o.System,
+		"tokenCount":     o.TokenCount,
+		"withinBudget":   o.WithinBudget,
 	}
 }
 
@@ -55,5 +79,25 @@ func (o *Output) FromMap(values map[string]interface{}) error {
 		return err
 	}
 
+	o.Messages, err = coerce.ToArray(values["messages"])
+	if err != nil {
+		return err
+	}
+
+	o.System, err = coerce.ToString(values["system"])
+	if err != nil {
+		return err
+	}
+
+	o.TokenCount, err = coerce.ToInt(values["tokenCount"])
+	if err != nil {
+		return err
+	}
+
+	o.WithinBudget, err = coerce.ToBool(values["withinBudget"])
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
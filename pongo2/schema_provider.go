@@ -2,8 +2,9 @@ package pongo2
 
 import (
 	"encoding/json"
-	"regexp"
 	"strings"
+
+	"github.com/project-flogo/core/data/metadata"
 )
 
 // SchemaProvider interface for dynamic schema generation
@@ -14,84 +15,209 @@ type SchemaProvider interface {
 // TemplateSchemaProvider generates schema based on template content
 type TemplateSchemaProvider struct{}
 
-// GetInputSchema generates schema for template variables based on template content
+// GetInputSchema generates schema for template variables based on template
+// content. Attribute paths become nested object properties and loop
+// iterables become arrays whose items carry the attributes used on the
+// loop variable. Templates loaded from files include the variables of the
+// templates they extend, include or import.
 func (tsp *TemplateSchemaProvider) GetInputSchema(settings map[string]interface{}) (map[string]interface{}, error) {
-	template, ok := settings["template"].(string)
-	if !ok || template == "" {
+	s := &Settings{}
+	if err := metadata.MapToStruct(settings, s, false); err != nil {
+		return nil, err
+	}
+	if s.TemplatePath == "" && s.Template == "" {
 		return nil, nil
 	}
 
-	variables := extractTemplateVariables(template)
-	forLoopArrays := extractForLoopArrays(template)
+	set, compiled, err := loadTemplate(s)
+	if err != nil {
+		return nil, err
+	}
+	analysis, err := set.analyze(compiled)
+	if err != nil {
+		return nil, err
+	}
 
-	properties := make(map[string]interface{})
-	for _, variable := range variables {
-		if isArrayVariable(variable, forLoopArrays) {
-			properties[variable] = map[string]interface{}{
-				"type":        "array",
-				"description": "Array variable used in for loop: {{ " + variable + " }}",
-				"items": map[string]interface{}{
-					"type":                 "object",
-					"additionalProperties": true,
-				},
-			}
-		} else {
-			properties[variable] = map[string]interface{}{
-				"type":        "string",
-				"description": "Template variable: {{ " + variable + " }}",
+	return buildInputSchema(analysis), nil
+}
+
+// schemaNode collects the ways a template uses one value
+type schemaNode struct {
+	path       string
+	properties map[string]*schemaNode
+	order      []string
+	items      *schemaNode // set when the value is iterated or indexed
+	values     *schemaNode // set when iterated as key, value pairs
+	required   bool        // set when the template uses the value unguarded
+}
+
+func newSchemaNode(path string) *schemaNode {
+	return &schemaNode{path: path, properties: make(map[string]*schemaNode)}
+}
+
+// child returns the node of a property, creating it on first use
+func (n *schemaNode) child(name string) *schemaNode {
+	if c, ok := n.properties[name]; ok {
+		return c
+	}
+	path := name
+	if n.path != "" {
+		path = n.path + "." + name
+	}
+	c := newSchemaNode(path)
+	n.properties[name] = c
+	n.order = append(n.order, name)
+	return c
+}
+
+// add walks a path such as customer.orders[].total into the tree. Required
+// paths mark every property along the way as required.
+func (n *schemaNode) add(path string, required bool) {
+	node := n
+	for _, part := range strings.Split(path, ".") {
+		name := strings.TrimRight(part, "[]{}")
+		node = node.child(name)
+		if required {
+			node.required = true
+		}
+		for marks := part[len(name):]; len(marks) >= 2; marks = marks[2:] {
+			switch marks[:2] {
+			case "[]":
+				if node.items == nil {
+					node.items = newSchemaNode(node.path + "[]")
+				}
+				node = node.items
+			case "{}":
+				if node.values == nil {
+					node.values = newSchemaNode(node.path + "{}")
+				}
+				node = node.values
 			}
 		}
 	}
+}
+
+// schema converts the node into a JSON schema. Values only printed or
+// compared are strings, values with attributes are objects and iterated
+// values are arrays. Scalar types are not inferred; validateVariables
+// accepts any scalar for a string.
+func (n *schemaNode) schema() map[string]interface{} {
+	switch {
+	case n.items != nil && len(n.properties) == 0:
+		return map[string]interface{}{
+			"type":        "array",
+			"description": "Array variable used in for loop: {{ " + n.path + " }}",
+			"items":       n.items.schema(),
+		}
+	case len(n.properties) > 0:
+		properties := make(map[string]interface{}, len(n.properties))
+		for _, name := range n.order {
+			properties[name] = n.properties[name].schema()
+		}
+		schema := map[string]interface{}{
+			"type":        "object",
+			"description": "Object variable: {{ " + n.path + " }}",
+			"properties":  properties,
+		}
+		if required := n.requiredProperties(); len(required) > 0 {
+			schema["required"] = required
+		}
+		if n.values != nil {
+			schema["additionalProperties"] = n.values.schema()
+		}
+		return schema
+	case n.values != nil:
+		return map[string]interface{}{
+			"type":                 "object",
+			"description":          "Object variable used in for loop: {{ " + n.path + " }}",
+			"additionalProperties": n.values.schema(),
+		}
+	case strings.HasSuffix(n.path, "[]") || strings.HasSuffix(n.path, "{}"):
+		return map[string]interface{}{
+			"type":        "string",
+			"description": "Loop item: {{ " + n.path + " }}",
+		}
+	default:
+		return map[string]interface{}{
+			"type":        "string",
+			"description": "Template variable: {{ " + n.path + " }}",
+		}
+	}
+}
+
+// requiredProperties lists the required properties in order of first use
+func (n *schemaNode) requiredProperties() []string {
+	var required []string
+	for _, name := range n.order {
+		if n.properties[name].required {
+			required = append(required, name)
+		}
+	}
+	return required
+}
+
+// buildInputSchema creates the JSON schema of the templateVariables input.
+// Variables the template uses outside if blocks, conditions and default
+// filters are listed as required.
+func buildInputSchema(analysis *templateAnalysis) map[string]interface{} {
+	optional := make(map[string]bool, len(analysis.Optional))
+	for _, path := range analysis.Optional {
+		optional[path] = true
+	}
+
+	root := newSchemaNode("")
+	for _, path := range analysis.Paths {
+		root.add(path, !optional[path])
+	}
+
+	properties := make(map[string]interface{}, len(analysis.Variables))
+	for _, variable := range analysis.Variables {
+		properties[variable] = root.child(variable).schema()
+	}
 
 	schema := map[string]interface{}{
 		"$schema":    "http://json-schema.org/draft-04/schema#",
 		"type":       "object",
 		"properties": properties,
 	}
-
-	return schema, nil
+	if required := root.requiredProperties(); len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
 }
 
-// extractTemplateVariables extracts variable names from pongo2 template using regex
+// extractTemplateVariables returns the free root variables of a pongo2
+// template: {{ user.name }} yields user, {{ a + b }} yields a and b, and
+// loop-bound names are left out. Templates that do not parse yield nil.
 func extractTemplateVariables(template string) []string {
-	// Regex to match {{ variable_name|filter }} or {{ variable_name }} patterns
-	// This captures everything inside {{ }} and then extracts the variable name before any filters
-	re := regexp.MustCompile(`\{\{\s*([^}|]+)(?:\|[^}]*)?\s*\}\}`)
-	matches := re.FindAllStringSubmatch(template, -1)
-
-	var variables []string
-	seen := make(map[string]bool)
-	for _, match := range matches {
-		if len(match) > 1 {
-			varName := strings.TrimSpace(match[1])
-			if varName != "" && !seen[varName] {
-				variables = append(variables, varName)
-				seen[varName] = true
-			}
-		}
+	analysis, err := analyzeTemplate(template)
+	if err != nil {
+		return nil
 	}
-	return variables
+	return analysis.Variables
 }
 
 // isLoopIterator checks if a variable name is a loop iterator
 func isLoopIterator(varName string, template string) bool {
-	pattern := `\{\%\s*for\s+` + regexp.QuoteMeta(varName) + `\s+in\s+`
-	re := regexp.MustCompile(pattern)
-	return re.MatchString(template)
+	analysis, err := analyzeTemplate(template)
+	if err != nil {
+		return false
+	}
+	for _, loopVar := range analysis.LoopVars {
+		if loopVar == varName {
+			return true
+		}
+	}
+	return false
 }
 
-// extractForLoopArrays finds arrays used in for loops
+// extractForLoopArrays finds the variables iterated by for loops
 func extractForLoopArrays(template string) []string {
-	re := regexp.MustCompile(`\{\%\s*for\s+\w+\s+in\s+([a-zA-Z_][a-zA-Z0-9_]*)\s*\%\}`)
-	matches := re.FindAllStringSubmatch(template, -1)
-
-	var arrays []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			arrays = append(arrays, match[1])
-		}
+	analysis, err := analyzeTemplate(template)
+	if err != nil {
+		return nil
 	}
-	return arrays
+	return analysis.arrays()
 }
 
 // isArrayVariable checks if a variable is an array used in for loops
@@ -106,52 +232,12 @@ func isArrayVariable(variable string, forLoopArrays []string) bool {
 
 // generateJSONSchemaForTemplate creates a JSON schema based on detected template variables
 func generateJSONSchemaForTemplate(template string) string {
-	variables := extractTemplateVariables(template)
-
-	// Detect arrays used in for loops
-	forLoopArrays := extractForLoopArrays(template)
-
-	// Add for loop arrays to variables if not already detected
-	for _, array := range forLoopArrays {
-		found := false
-		for _, variable := range variables {
-			if variable == array {
-				found = true
-				break
-			}
-		}
-		if !found {
-			variables = append(variables, array)
-		}
-	}
-
-	properties := make(map[string]interface{})
-	for _, variable := range variables {
-		// Check if this variable is used in a for loop
-		if isArrayVariable(variable, forLoopArrays) {
-			properties[variable] = map[string]interface{}{
-				"type":        "array",
-				"description": "Array variable used in for loop: {{ " + variable + " }}",
-				"items": map[string]interface{}{
-					"type":                 "object",
-					"additionalProperties": true,
-				},
-			}
-		} else {
-			properties[variable] = map[string]interface{}{
-				"type":        "string",
-				"description": "Template variable: {{ " + variable + " }}",
-			}
-		}
-	}
-
-	schema := map[string]interface{}{
-		"$schema":    "http://json-schema.org/draft-04/schema#",
-		"type":       "object",
-		"properties": properties,
+	analysis, err := analyzeTemplate(template)
+	if err != nil {
+		analysis = &templateAnalysis{}
 	}
 
-	schemaBytes, _ := json.Marshal(schema)
+	schemaBytes, _ := json.Marshal(buildInputSchema(analysis))
 	return string(schemaBytes)
 }
 
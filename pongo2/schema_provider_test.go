@@ -0,0 +1,108 @@
+package pongo2
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetInputSchemaNested(t *testing.T) {
+	template := `{{ customer.name }} from {{ customer.address.city }}
+{% for o in customer.orders %}{{ o.total }} {% for tag in o.tags %}{{ tag }}{% endfor %}{% endfor %}
+{% for note in notes %}{{ note }}{% endfor %}
+{% for key, value in limits %}{{ key }}: {{ value.max }}{% endfor %}`
+
+	provider := &TemplateSchemaProvider{}
+	schema, err := provider.GetInputSchema(map[string]interface{}{"template": template})
+	require.NoError(t, err)
+
+	actual, err := json.Marshal(schema)
+	require.NoError(t, err)
+
+	expected := `{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"type": "object",
+		"properties": {
+			"customer": {
+				"type": "object",
+				"description": "Object variable: {{ customer }}",
+				"properties": {
+					"name": {"type": "string", "description": "Template variable: {{ customer.name }}"},
+					"address": {
+						"type": "object",
+						"description": "Object variable: {{ customer.address }}",
+						"properties": {
+							"city": {"type": "string", "description": "Template variable: {{ customer.address.city }}"}
+						},
+						"required": ["city"]
+					},
+					"orders": {
+						"type": "array",
+						"description": "Array variable used in for loop: {{ customer.orders }}",
+						"items": {
+							"type": "object",
+							"description": "Object variable: {{ customer.orders[] }}",
+							"properties": {
+								"total": {"type": "string", "description": "Template variable: {{ customer.orders[].total }}"},
+								"tags": {
+									"type": "array",
+									"description": "Array variable used in for loop: {{ customer.orders[].tags }}",
+									"items": {"type": "string", "description": "Loop item: {{ customer.orders[].tags[] }}"}
+								}
+							},
+							"required": ["total", "tags"]
+						}
+					}
+				},
+				"required": ["name", "address", "orders"]
+			},
+			"notes": {
+				"type": "array",
+				"description": "Array variable used in for loop: {{ notes }}",
+				"items": {"type": "string", "description": "Loop item: {{ notes[] }}"}
+			},
+			"limits": {
+				"type": "object",
+				"description": "Object variable used in for loop: {{ limits }}",
+				"additionalProperties": {
+					"type": "object",
+					"description": "Object variable: {{ limits{} }}",
+					"properties": {
+						"max": {"type": "string", "description": "Template variable: {{ limits{}.max }}"}
+					},
+					"required": ["max"]
+				}
+			}
+		},
+		"required": ["customer", "notes", "limits"]
+	}`
+	assert.JSONEq(t, expected, string(actual))
+}
+
+func TestGetInputSchemaOptionalVariables(t *testing.T) {
+	template := `{{ name }}{% if title %}, {{ title }}{% endif %}
+{{ greeting|default:"Hello" }}
+{% if user.address %}{{ user.address.city }}{% endif %}{{ user.id }}`
+
+	provider := &TemplateSchemaProvider{}
+	schema, err := provider.GetInputSchema(map[string]interface{}{"template": template})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"name", "user"}, schema["required"])
+	user := schema["properties"].(map[string]interface{})["user"].(map[string]interface{})
+	assert.Equal(t, []string{"id"}, user["required"])
+}
+
+func TestGetInputSchemaInvalidTemplate(t *testing.T) {
+	provider := &TemplateSchemaProvider{}
+
+	schema, err := provider.GetInputSchema(map[string]interface{}{"template": "{% if x %}"})
+	assert.Error(t, err)
+	assert.Nil(t, schema)
+
+	schema, err = provider.GetInputSchema(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Nil(t, schema)
+}
@@ -0,0 +1,192 @@
+package pongo2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// pongo2 renders a variable that does not resolve as an empty string and
+// has no hook to change that. In strict undefined mode every template is
+// rewritten before it is compiled: each block that reads variables is
+// preceded by a strictvar tag per variable, which fails the render when the
+// variable does not resolve. Reads in if conditions and default filters are
+// left alone, those are the template's own checks.
+//
+//	{{ order.total }}  ->  {% strictvar "order.total" 1 4 %}{{ order.total }}
+
+// strictVarTagNode checks {% strictvar "path" line col %}
+type strictVarTagNode struct {
+	path     string
+	segments []string
+	filename string
+	line     int
+	col      int
+}
+
+func (node *strictVarTagNode) Execute(ctx *pongo2.ExecutionContext, writer pongo2.TemplateWriter) *pongo2.Error {
+	if defined(ctx, node.segments) {
+		return nil
+	}
+	return &pongo2.Error{
+		Sender:    "strict",
+		Filename:  node.filename,
+		Line:      node.line,
+		Column:    node.col,
+		Token:     &pongo2.Token{Filename: node.filename, Typ: pongo2.TokenIdentifier, Val: node.segments[0], Line: node.line, Col: node.col},
+		OrigError: fmt.Errorf("variable '%s' is undefined", node.path),
+	}
+}
+
+func strictVarTagParser(doc *pongo2.Parser, start *pongo2.Token, arguments *pongo2.Parser) (pongo2.INodeTag, *pongo2.Error) {
+	path := arguments.MatchType(pongo2.TokenString)
+	line := arguments.MatchType(pongo2.TokenNumber)
+	col := arguments.MatchType(pongo2.TokenNumber)
+	if path == nil || line == nil || col == nil || arguments.Remaining() > 0 {
+		return nil, arguments.Error("strictvar tag requires a variable path, a line and a column.", nil)
+	}
+
+	node := &strictVarTagNode{path: path.Val, segments: strings.Split(path.Val, "."), filename: start.Filename}
+	node.line, _ = strconv.Atoi(line.Val)
+	node.col, _ = strconv.Atoi(col.Val)
+	return node, nil
+}
+
+// defined resolves a variable path the way pongo2 does: private names such
+// as loop variables and macros first, then the template context; attributes
+// are methods, map keys or struct fields, numbers index slices and strings
+func defined(ctx *pongo2.ExecutionContext, segments []string) bool {
+	current, ok := ctx.Private[segments[0]]
+	if !ok {
+		if current, ok = ctx.Public[segments[0]]; !ok {
+			return false
+		}
+	}
+
+	for _, segment := range segments[1:] {
+		if value, ok := current.(*pongo2.Value); ok {
+			current = value.Interface()
+		}
+		rv := reflect.ValueOf(current)
+		if !rv.IsValid() {
+			return false
+		}
+
+		index, err := strconv.Atoi(segment)
+		if err != nil && rv.MethodByName(segment).IsValid() {
+			// pongo2 calls the method; its result is not checked further
+			return true
+		}
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				return false
+			}
+			rv = rv.Elem()
+		}
+
+		var next reflect.Value
+		switch {
+		case err == nil:
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Array, reflect.String:
+				if index >= 0 && index < rv.Len() {
+					next = rv.Index(index)
+				}
+			}
+		case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+			next = rv.MapIndex(reflect.ValueOf(segment).Convert(rv.Type().Key()))
+		case rv.Kind() == reflect.Struct:
+			next = rv.FieldByName(segment)
+		}
+		if !next.IsValid() {
+			return false
+		}
+		current = next.Interface()
+	}
+	return true
+}
+
+// strictSource inserts the strictvar tags for the references of an analyzed
+// template into its source
+func strictSource(source string, references []templateReference) string {
+	if len(references) == 0 {
+		return source
+	}
+
+	lineStarts := []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+
+	var out strings.Builder
+	written := 0
+	for _, ref := range references {
+		offset := lineStarts[ref.Block.Line-1] + ref.Block.Col - 1
+		out.WriteString(source[written:offset])
+		written = offset
+
+		// Keep the whitespace control of the block, {{- trims the text
+		// before the inserted tag instead of the text before the block
+		open := "{%"
+		if strings.HasPrefix(source[offset:], "{{-") || strings.HasPrefix(source[offset:], "{%-") {
+			open = "{%-"
+		}
+		fmt.Fprintf(&out, "%s strictvar %q %d %d %%}", open, ref.Path, ref.Line, ref.Col)
+	}
+	out.WriteString(source[written:])
+	return out.String()
+}
+
+// strictLoader rewrites the templates its loader returns, so templates
+// loaded by name and the ones they extend, include and import are checked.
+// The plain template set parses the original source for the analysis.
+type strictLoader struct {
+	pongo2.TemplateLoader
+	plain *pongo2.TemplateSet
+}
+
+func (l *strictLoader) Get(name string) (io.Reader, error) {
+	fd, err := l.TemplateLoader.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	source, err := io.ReadAll(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := l.plain.FromCache(name)
+	if err != nil {
+		// Leave the error to pongo2 when it parses the original source
+		return bytes.NewReader(source), nil
+	}
+	tokens, err := templateTokens(plain)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(strictSource(string(source), analyzeTokens(tokens).References)), nil
+}
+
+// fromString compiles a template given as text, rewritten in strict
+// undefined mode
+func (set *templateSet) fromString(source string) (*pongo2.Template, error) {
+	if set.plain == nil {
+		return set.FromString(source)
+	}
+	plain, err := set.plain.FromString(source)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := templateTokens(plain)
+	if err != nil {
+		return nil, err
+	}
+	return set.FromString(strictSource(source, analyzeTokens(tokens).References))
+}
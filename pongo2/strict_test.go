@@ -0,0 +1,187 @@
+package pongo2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type strictCustomer struct {
+	Name string
+}
+
+func (c strictCustomer) Greeting() string { return "Hello " + c.Name }
+
+func renderStrict(t *testing.T, settings map[string]interface{}, variables map[string]interface{}) (string, error) {
+	settings["strictUndefined"] = true
+	act, err := New(&MockInitContext{settings: settings})
+	require.NoError(t, err)
+
+	evalCtx := &MockActivityContext{
+		inputs:  map[string]interface{}{"templateVariables": variables},
+		outputs: make(map[string]interface{}),
+	}
+	if _, err := act.Eval(evalCtx); err != nil {
+		return "", err
+	}
+	return evalCtx.outputs["renderedPrompt"].(string), nil
+}
+
+func TestStrictUndefined(t *testing.T) {
+	order := map[string]interface{}{
+		"customer": map[string]interface{}{"name": "Ada"},
+		"lines": []interface{}{
+			map[string]interface{}{"sku": "A-1", "qty": 2},
+			map[string]interface{}{"sku": "B-2"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		template  string
+		variables map[string]interface{}
+		want      string
+		err       string
+	}{
+		{
+			name:      "missing variable",
+			template:  "Hello {{ name }}!",
+			variables: map[string]interface{}{},
+			err:       "Line 1 Col 10 near 'name'] variable 'name' is undefined",
+		},
+		{
+			name:      "missing attribute in a loop",
+			template:  "Order for {{ order.customer.name }}\n{% for line in order.lines %}\n- {{ line.sku }} x{{ line.qty }}{% endfor %}",
+			variables: map[string]interface{}{"order": order},
+			err:       "Line 3 Col 22 near 'line'] variable 'line.qty' is undefined",
+		},
+		{
+			name:      "index out of range",
+			template:  "{{ order.lines.2.sku }}",
+			variables: map[string]interface{}{"order": order},
+			err:       "variable 'order.lines.2.sku' is undefined",
+		},
+		{
+			name:      "attribute of a string",
+			template:  "{{ order.customer.name.first }}",
+			variables: map[string]interface{}{"order": order},
+			err:       "variable 'order.customer.name.first' is undefined",
+		},
+		{
+			name:      "tag arguments",
+			template:  "{% with total=order.total %}{{ total }}{% endwith %}",
+			variables: map[string]interface{}{"order": order},
+			err:       "variable 'order.total' is undefined",
+		},
+		{
+			name:      "defined values",
+			template:  "{{ order.customer.name }} {{ order.lines.0.sku }} {{ order.lines.0.qty }} {{ customer.Name }} {{ customer.Greeting }}",
+			variables: map[string]interface{}{"order": order, "customer": &strictCustomer{Name: "Bob"}},
+			want:      "Ada A-1 2 Bob Hello Bob",
+		},
+		{
+			name:      "conditions and defaults are checks",
+			template:  "{% if note %}{{ note }}{% elif order.note %}x{% endif %}{{ order.note|default:\"none\" }}{% firstof a b \"c\" %}",
+			variables: map[string]interface{}{"order": order},
+			want:      "nonec",
+		},
+		{
+			name:      "local names",
+			template:  "{% macro item(text, mark=\"-\") %}{{ mark }} {{ text }}{% endmacro %}{% set title = \"Lines\" %}{{ title }}:{% for line in order.lines %} {{ forloop.Counter }}{{ item(line.sku) }}{% endfor %}{% with n=order.customer.name %} {{ n }}{% endwith %}",
+			variables: map[string]interface{}{"order": order},
+			want:      "Lines: 1- A-1 2- B-2 Ada",
+		},
+		{
+			name:      "defined as nil",
+			template:  "[{{ order.customer.nickname }}]",
+			variables: map[string]interface{}{"order": map[string]interface{}{"customer": map[string]interface{}{"nickname": nil}}},
+			want:      "[]",
+		},
+		{
+			name:      "whitespace control",
+			template:  "Items:\n  {{- order.customer.name }}\n{%- for line in order.lines %} {{ line.sku }}{% endfor %}",
+			variables: map[string]interface{}{"order": order},
+			want:      "Items:Ada A-1 B-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := renderStrict(t, map[string]interface{}{"template": tt.template}, tt.variables)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, result)
+		})
+	}
+}
+
+func TestStrictUndefinedIncludes(t *testing.T) {
+	dir := promptLibrary(t)
+
+	// The footer is included by the layout; its variable is checked too
+	variables := map[string]interface{}{
+		"role":  "a code reviewer",
+		"files": []interface{}{map[string]interface{}{"name": "main.go"}},
+	}
+	_, err := renderStrict(t, map[string]interface{}{
+		"templateSource":  "directory",
+		"templateBaseDir": dir,
+		"templatePath":    "review.txt",
+	}, variables)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(dir, "partials", "footer.txt")+" | Line 1 Col 14")
+	assert.Contains(t, err.Error(), "variable 'language' is undefined")
+
+	result, err := renderStrict(t, map[string]interface{}{
+		"templateSource":  "directory",
+		"templateBaseDir": dir,
+		"templatePath":    "review.txt",
+	}, reviewVariables)
+	require.NoError(t, err)
+	assert.Equal(t, reviewPrompt, result)
+}
+
+func TestStrictUndefinedTemplateInput(t *testing.T) {
+	dir := promptLibrary(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "task.txt"), []byte("Task: {{ task }}"), 0o644))
+
+	act, err := New(&MockInitContext{settings: map[string]interface{}{
+		"template":        "unused",
+		"templateBaseDir": dir,
+		"strictUndefined": true,
+	}})
+	require.NoError(t, err)
+
+	evalCtx := &MockActivityContext{
+		inputs: map[string]interface{}{
+			"template":          `{{ greeting }} {% include "task.txt" %}`,
+			"templateVariables": map[string]interface{}{"greeting": "Hi"},
+		},
+		outputs: make(map[string]interface{}),
+	}
+	_, err = act.Eval(evalCtx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "variable 'task' is undefined")
+}
+
+func TestStrictUndefinedSchema(t *testing.T) {
+	// The inserted checks are not template inputs
+	provider := &TemplateSchemaProvider{}
+	schema, err := provider.GetInputSchema(map[string]interface{}{
+		"template":        "{{ order.total }} {% if note %}{{ note }}{% endif %}",
+		"strictUndefined": true,
+	})
+	require.NoError(t, err)
+
+	properties := schema["properties"].(map[string]interface{})
+	assert.Len(t, properties, 2)
+	assert.Contains(t, properties, "order")
+	assert.Contains(t, properties, "note")
+}
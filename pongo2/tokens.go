@@ -0,0 +1,120 @@
+package pongo2
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// tokenEncoding is the BPE encoding used to count tokens. Its vocabulary is
+// compiled into the binary, so counting works without network access.
+const tokenEncoding = "cl100k_base"
+
+// truncationMarker replaces the tokens removed by truncate_middle
+const truncationMarker = "\n…\n"
+
+var (
+	encoderOnce sync.Once
+	encoder     *tiktoken.Tiktoken
+	encoderErr  error
+)
+
+// tokenEncoder loads the encoding on first use, by an Eval with a token
+// budget or countTokens, or by a token filter. Building the BPE ranks takes
+// a noticeable moment, so processes that never count tokens skip it.
+func tokenEncoder() (*tiktoken.Tiktoken, error) {
+	encoderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+		encoder, encoderErr = tiktoken.GetEncoding(tokenEncoding)
+		if encoderErr != nil {
+			encoderErr = fmt.Errorf("failed to load token encoding %s: %w", tokenEncoding, encoderErr)
+		}
+	})
+	return encoder, encoderErr
+}
+
+// countTokens returns the number of tokens of a text. Special tokens such
+// as <|endoftext|> are counted as ordinary text.
+func countTokens(text string) (int, error) {
+	enc, err := tokenEncoder()
+	if err != nil {
+		return 0, err
+	}
+	return len(enc.EncodeOrdinary(text)), nil
+}
+
+// decodeTokens decodes a token range, dropping the bytes of a character
+// split at either end of the range
+func decodeTokens(enc *tiktoken.Tiktoken, tokens []int) string {
+	text := enc.Decode(tokens)
+	for text != "" {
+		r, size := utf8.DecodeRuneInString(text)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		text = text[1:]
+	}
+	for text != "" {
+		r, size := utf8.DecodeLastRuneInString(text)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// filterTokenCount implements {{ text|tokencount }}
+func filterTokenCount(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	count, err := countTokens(in.String())
+	if err != nil {
+		return nil, filterError("tokencount", "%v", err)
+	}
+	return pongo2.AsValue(count), nil
+}
+
+// filterTruncateTokens implements {{ text|truncate_tokens:N }}, keeping the
+// first N tokens
+func filterTruncateTokens(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return truncate(in, param, "truncate_tokens", func(enc *tiktoken.Tiktoken, tokens []int, limit int) string {
+		return decodeTokens(enc, tokens[:limit])
+	})
+}
+
+// filterTruncateMiddle implements {{ text|truncate_middle:N }}, keeping the
+// first and last tokens of a text that exceeds N tokens. The beginning of a
+// document and its most recent part usually matter most, e.g. for logs and
+// conversation history.
+func filterTruncateMiddle(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return truncate(in, param, "truncate_middle", func(enc *tiktoken.Tiktoken, tokens []int, limit int) string {
+		head := (limit + 1) / 2
+		tail := limit - head
+		return strings.TrimRight(decodeTokens(enc, tokens[:head]), " ") + truncationMarker +
+			strings.TrimLeft(decodeTokens(enc, tokens[len(tokens)-tail:]), " ")
+	})
+}
+
+// truncate applies cut to texts longer than the token limit in param and
+// returns shorter texts unchanged
+func truncate(in *pongo2.Value, param *pongo2.Value, name string, cut func(*tiktoken.Tiktoken, []int, int) string) (*pongo2.Value, *pongo2.Error) {
+	if !param.IsInteger() || param.Integer() < 0 {
+		return nil, filterError(name, "%s requires a token limit, e.g. %s:500", name, name)
+	}
+	limit := param.Integer()
+
+	enc, err := tokenEncoder()
+	if err != nil {
+		return nil, filterError(name, "%v", err)
+	}
+	text := in.String()
+	tokens := enc.EncodeOrdinary(text)
+	if len(tokens) <= limit {
+		return pongo2.AsValue(text), nil
+	}
+	return pongo2.AsValue(cut(enc, tokens, limit)), nil
+}
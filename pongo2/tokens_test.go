@@ -0,0 +1,114 @@
+package pongo2
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text  string
+		count int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"tiktoken is great!", 6},
+		{"<|endoftext|>", 7},
+	}
+	for _, tt := range tests {
+		count, err := countTokens(tt.text)
+		require.NoError(t, err)
+		assert.Equal(t, tt.count, count, tt.text)
+	}
+}
+
+func TestTokenFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"tokencount", "{{ text|tokencount }}", "9"},
+		{"truncate_tokens", "{{ text|truncate_tokens:3 }}", "one two three"},
+		{"truncate_tokens keeps short text", "{{ text|truncate_tokens:100 }}", "one two three four five six seven eight nine"},
+		{"truncate_middle", "{{ text|truncate_middle:4 }}", "one two\n…\neight nine"},
+		{"truncate_middle odd limit", "{{ text|truncate_middle:3 }}", "one two\n…\nnine"},
+		{"count after truncation", "{{ text|truncate_tokens:5|tokencount }}", "5"},
+		{"multi-byte characters are not split", "{{ emoji|truncate_tokens:1 }}", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl, err := pongo2.FromString(tt.template)
+			require.NoError(t, err)
+			out, err := tpl.Execute(pongo2.Context{
+				"text":  "one two three four five six seven eight nine",
+				"emoji": "🦜",
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+		})
+	}
+}
+
+func TestTruncateFilterRequiresLimit(t *testing.T) {
+	for _, template := range []string{"{{ text|truncate_tokens }}", `{{ text|truncate_middle:"ten" }}`} {
+		tpl, err := pongo2.FromString(template)
+		require.NoError(t, err)
+		_, err = tpl.Execute(pongo2.Context{"text": "hello"})
+		require.Error(t, err, template)
+		assert.Contains(t, err.Error(), "requires a token limit")
+	}
+}
+
+func TestTokenBudget(t *testing.T) {
+	act, err := New(&MockInitContext{settings: map[string]interface{}{
+		"template":    "{{ text }}",
+		"tokenBudget": 5,
+	}})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		words        int
+		withinBudget bool
+	}{{5, true}, {6, false}} {
+		evalCtx := &MockActivityContext{
+			inputs: map[string]interface{}{
+				"templateVariables": map[string]interface{}{"text": strings.TrimSpace(strings.Repeat(" word", tt.words))},
+			},
+			outputs: make(map[string]interface{}),
+		}
+		_, err = act.Eval(evalCtx)
+		require.NoError(t, err)
+		assert.Equal(t, tt.words, evalCtx.outputs["tokenCount"])
+		assert.Equal(t, tt.withinBudget, evalCtx.outputs["withinBudget"])
+	}
+
+	_, err = New(&MockInitContext{settings: map[string]interface{}{"template": "x", "tokenBudget": -1}})
+	assert.Error(t, err)
+}
+
+func TestCountTokensSetting(t *testing.T) {
+	eval := func(settings map[string]interface{}) map[string]interface{} {
+		settings["template"] = "one two three"
+		act, err := New(&MockInitContext{settings: settings})
+		require.NoError(t, err)
+		evalCtx := &MockActivityContext{inputs: map[string]interface{}{}, outputs: make(map[string]interface{})}
+		_, err = act.Eval(evalCtx)
+		require.NoError(t, err)
+		return evalCtx.outputs
+	}
+
+	// Without a budget the prompt is only tokenized on request
+	outputs := eval(map[string]interface{}{})
+	assert.Equal(t, 0, outputs["tokenCount"])
+	assert.Equal(t, true, outputs["withinBudget"])
+
+	outputs = eval(map[string]interface{}{"countTokens": true})
+	assert.Equal(t, 3, outputs["tokenCount"])
+	assert.Equal(t, true, outputs["withinBudget"])
+}
@@ -0,0 +1,132 @@
+package pongo2
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// validateVariables checks template variables against the schema built by
+// buildInputSchema and returns one message per violation, sorted by path.
+// Missing and nil values violate "required"; values only printed by the
+// template may be any scalar.
+func validateVariables(schema map[string]interface{}, variables map[string]interface{}) []string {
+	var violations []string
+	validateObject(schema, variables, "", &violations)
+	sort.Strings(violations)
+	return violations
+}
+
+func validateValue(schema map[string]interface{}, value interface{}, path string, violations *[]string) {
+	switch schema["type"] {
+	case "object":
+		object, ok := asObject(value)
+		if !ok {
+			*violations = append(*violations, fmt.Sprintf("%s: expected object, got %s", path, kindOf(value)))
+			return
+		}
+		validateObject(schema, object, path, violations)
+	case "array":
+		items, ok := asArray(value)
+		if !ok {
+			*violations = append(*violations, fmt.Sprintf("%s: expected array, got %s", path, kindOf(value)))
+			return
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		if itemSchema == nil {
+			return
+		}
+		for i, item := range items {
+			validateValue(itemSchema, item, fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	case "string":
+		if kind := kindOf(value); kind == "object" || kind == "array" {
+			*violations = append(*violations, fmt.Sprintf("%s: expected string, got %s", path, kind))
+		}
+	}
+}
+
+func validateObject(schema map[string]interface{}, object map[string]interface{}, path string, violations *[]string) {
+	required, _ := schema["required"].([]string)
+	for _, name := range required {
+		if object[name] == nil {
+			*violations = append(*violations, fmt.Sprintf("%s: required variable is missing", joinPath(path, name)))
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	additional, _ := schema["additionalProperties"].(map[string]interface{})
+	for name, value := range object {
+		if value == nil {
+			continue
+		}
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			validateValue(property, value, joinPath(path, name), violations)
+		} else if additional != nil {
+			validateValue(additional, value, joinPath(path, name), violations)
+		}
+	}
+}
+
+// asObject accepts maps with string keys, the shape JSON objects decode to
+func asObject(value interface{}) (map[string]interface{}, bool) {
+	if object, ok := value.(map[string]interface{}); ok {
+		return object, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	object := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		object[iter.Key().String()] = iter.Value().Interface()
+	}
+	return object, true
+}
+
+// asArray accepts slices and arrays of any element type
+func asArray(value interface{}) ([]interface{}, bool) {
+	if items, ok := value.([]interface{}); ok {
+		return items, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, true
+}
+
+// kindOf names the JSON type of a value for violation messages
+func kindOf(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
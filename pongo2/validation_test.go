@@ -0,0 +1,70 @@
+package pongo2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateVariables(t *testing.T) {
+	template := `{{ customer.name }}
+{% for o in customer.orders %}{{ o.total }}{% for tag in o.tags %}{{ tag }}{% endfor %}{% endfor %}
+{% if notes %}{{ notes }}{% endif %}
+{% for key, value in limits %}{{ key }}: {{ value.max }}{% endfor %}`
+
+	analysis, err := analyzeTemplate(template)
+	require.NoError(t, err)
+	schema := buildInputSchema(analysis)
+
+	tests := []struct {
+		name       string
+		variables  map[string]interface{}
+		violations []string
+	}{
+		{
+			name: "valid",
+			variables: map[string]interface{}{
+				"customer": map[string]interface{}{
+					"name": "Ada",
+					"orders": []interface{}{
+						map[string]interface{}{"total": 12.5, "tags": []string{"new"}},
+					},
+				},
+				"limits": map[string]interface{}{"cpu": map[string]interface{}{"max": 4}},
+			},
+		},
+		{
+			name:       "missing required variables",
+			variables:  map[string]interface{}{},
+			violations: []string{"customer: required variable is missing", "limits: required variable is missing"},
+		},
+		{
+			name: "wrong types and item shapes",
+			variables: map[string]interface{}{
+				"customer": map[string]interface{}{
+					"name": map[string]interface{}{"first": "Ada"},
+					"orders": []interface{}{
+						map[string]interface{}{"total": 1, "tags": []interface{}{}},
+						map[string]interface{}{"tags": "vip"},
+					},
+				},
+				"notes":  []interface{}{"a"},
+				"limits": map[string]interface{}{"cpu": 4},
+			},
+			violations: []string{
+				"customer.name: expected string, got object",
+				"customer.orders[1].tags: expected array, got string",
+				"customer.orders[1].total: required variable is missing",
+				"limits.cpu: expected object, got number",
+				"notes: expected string, got array",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.violations, validateVariables(schema, tt.variables))
+		})
+	}
+}
@@ -2,10 +2,14 @@ package prometheusmetrics
 
 import (
 	"fmt"
+	"math"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/project-flogo/core/activity"
 	"github.com/project-flogo/core/data/coerce"
@@ -19,8 +23,61 @@ const (
 	sIncludeType = "includeType"
 	sTimestamp   = "timestamp"
 	ivMetricData = "metricData"
+
+	sExemplarLabelField = "exemplarLabelField"
+	sExemplarLabelName  = "exemplarLabelName"
+	sOutputFormat       = "outputFormat"
+	sStatsdAddress      = "statsdAddress"
+	sInfluxPrecision    = "influxPrecision"
+	sOTLPEndpoint       = "otlpEndpoint"
+	sOTLPProtocol       = "otlpProtocol"
+	sOTLPHeaders        = "otlpHeaders"
+	sResourceAttributes = "resourceAttributes"
+	sDerivedMetrics     = "derivedMetrics"
+	sNameEscaping       = "nameEscaping"
+	sMaxLabelValueLen   = "maxLabelValueLength"
+	sNullHandling       = "nullHandling"
+	sSelfMetrics        = "selfMetrics"
+	sSelfMetricsNS      = "selfMetricsNamespace"
+	sTemporality        = "temporality"
+	sFirstSeen          = "firstSeen"
+	sSeriesTTL          = "seriesTTL"
+	sMaxSeries          = "maxSeries"
+	sLabelNormalization = "labelNormalization"
+	sLabelPrefix        = "labelPrefix"
+	sInfoMetrics        = "infoMetrics"
+	sReservedFields     = "reservedFields"
+	sExtraReserved      = "extraReservedFields"
+)
+
+// Supported values of the reservedFields setting. Legacy reserves help,
+// timestamp and type; namespaced reserves _help, _timestamp and _type so
+// payloads can use the plain names as labels.
+const (
+	reservedLegacy     = "legacy"
+	reservedNamespaced = "namespaced"
+)
+
+// Supported values of the nullHandling setting
+const (
+	nullSkip  = "skip"
+	nullStale = "stale"
+)
+
+// Supported values of the outputFormat setting
+const (
+	formatPrometheus = "prometheus"
+	formatDogStatsD  = "dogstatsd"
+	formatInflux     = "influx"
+	formatOTLP       = "otlp"
+	// formatOpenMetrics is the only text output that carries exemplars
+	formatOpenMetrics = "openmetrics"
 )
 
+// maxExemplarLabelRunes is the OpenMetrics limit on the combined length of
+// an exemplar's label names and values.
+const maxExemplarLabelRunes = 128
+
 // activityMd is the metadata for the activity.
 var activityMd = activity.ToMetadata(&Settings{}, &Input{}, &Output{})
 
@@ -31,6 +88,63 @@ type Activity struct {
 	includeHelp bool
 	includeType bool
 	timestamp   bool
+
+	// exemplarField is the metric object field holding the exemplar label
+	// value (e.g. a trace ID). Exemplars are disabled when it is empty.
+	exemplarField     string
+	exemplarLabelName string
+
+	// outputFormat selects the encoding of the formattedMetrics output
+	outputFormat string
+	// statsdAddress is the host:port of a StatsD agent to send metrics to.
+	// Nothing is sent when it is empty.
+	statsdAddress string
+	// influxPrecision is the timestamp precision of line protocol output
+	influxPrecision string
+
+	// otlpEndpoint is an OTLP/HTTP metrics URL to push to. Nothing is pushed
+	// when it is empty.
+	otlpEndpoint       string
+	otlpProtocol       string
+	otlpHeaders        []labelPair
+	resourceAttributes []labelPair
+	// startTime is reported as the start of cumulative OTLP series
+	startTime time.Time
+
+	// derivedMetrics are extra series computed from each metric object
+	derivedMetrics []derivedMetric
+
+	// nameEscaping is the scheme applied to metric and label names
+	nameEscaping string
+	// maxLabelValueLength truncates longer label values. Zero means unlimited.
+	maxLabelValueLength int
+	// nullHandling decides whether JSON null fields are skipped or emitted
+	// as stale markers
+	nullHandling string
+
+	// selfMetrics appends the activity's own counters to the output
+	selfMetrics          bool
+	selfMetricsNamespace string
+	stats                selfStats
+
+	// temporality converts counters between delta and cumulative values
+	// using per-series state kept by tracker
+	temporality string
+	firstSeen   string
+	tracker     temporalityTracker
+
+	// labelNormalization and labelPrefix are applied to label names and to
+	// the field names used as name label values
+	labelNormalization string
+	labelPrefix        string
+
+	// infoMetrics turns objects without numeric fields into an info metric
+	infoMetrics bool
+
+	// reservedFields selects the reserved key names; extraReserved lists
+	// additional keys that are ignored
+	reservedFields string
+	extraReserved  []string
 }
 
 func init() {
@@ -50,12 +164,131 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 		return nil, err
 	}
 
+	if s.ExemplarLabelField != "" && !isValidLabelName(s.ExemplarLabelName) {
+		return nil, fmt.Errorf("invalid exemplar label name '%s'", s.ExemplarLabelName)
+	}
+
+	switch s.OutputFormat {
+	case formatPrometheus, formatOpenMetrics, formatDogStatsD, formatInflux, formatOTLP:
+	default:
+		return nil, fmt.Errorf("unsupported output format '%s'", s.OutputFormat)
+	}
+
+	if _, ok := influxPrecisionDivisors[s.InfluxPrecision]; !ok {
+		return nil, fmt.Errorf("unsupported InfluxDB timestamp precision '%s'", s.InfluxPrecision)
+	}
+
+	if s.OTLPProtocol != otlpProtocolProtobuf && s.OTLPProtocol != otlpProtocolJSON {
+		return nil, fmt.Errorf("unsupported OTLP protocol '%s'", s.OTLPProtocol)
+	}
+
+	otlpHeaders, err := parseKeyValueList(s.OTLPHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP headers: %w", err)
+	}
+
+	resourceAttributes, err := parseKeyValueList(s.ResourceAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource attributes: %w", err)
+	}
+
+	if s.MaxLabelValueLength < 0 {
+		return nil, fmt.Errorf("maximum label value length must not be negative")
+	}
+
+	if s.NullHandling != nullSkip && s.NullHandling != nullStale {
+		return nil, fmt.Errorf("unsupported null handling '%s'", s.NullHandling)
+	}
+
+	if !isValidLegacyName(s.SelfMetricsNamespace, true) {
+		return nil, fmt.Errorf("invalid self metrics namespace '%s'", s.SelfMetricsNamespace)
+	}
+
+	switch s.Temporality {
+	case temporalityNone:
+	case temporalityDeltaToCumulative, temporalityCumulativeToDelta:
+		if s.MetricType != "counter" {
+			return nil, fmt.Errorf("temporality '%s' requires metric type counter, got '%s'", s.Temporality, s.MetricType)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported temporality '%s'", s.Temporality)
+	}
+
+	if s.FirstSeen != firstSeenDrop && s.FirstSeen != firstSeenEmit {
+		return nil, fmt.Errorf("unsupported first seen behavior '%s'", s.FirstSeen)
+	}
+
+	if s.SeriesTTL < 0 || s.MaxSeries < 0 {
+		return nil, fmt.Errorf("series TTL and maximum series must not be negative")
+	}
+
+	switch s.LabelNormalization {
+	case normalizeNone, normalizeLowercase, normalizeCamelToSnake, normalizeSnakeCase:
+	default:
+		return nil, fmt.Errorf("unsupported label normalization '%s'", s.LabelNormalization)
+	}
+
+	if s.ReservedFields != reservedLegacy && s.ReservedFields != reservedNamespaced {
+		return nil, fmt.Errorf("unsupported reserved fields mode '%s'", s.ReservedFields)
+	}
+
+	var extraReserved []string
+	for _, field := range strings.Split(s.ExtraReservedFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			extraReserved = append(extraReserved, field)
+		}
+	}
+
+	switch s.NameEscaping {
+	case escapingUnderscores, escapingDots, escapingValues, escapingAllowUTF8:
+	default:
+		return nil, fmt.Errorf("unsupported name escaping scheme '%s'", s.NameEscaping)
+	}
+
+	derivedMetrics, err := parseDerivedMetrics(s.DerivedMetrics)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.StatsdAddress != "" {
+		if _, _, err := net.SplitHostPort(s.StatsdAddress); err != nil {
+			return nil, fmt.Errorf("invalid StatsD address '%s': %w", s.StatsdAddress, err)
+		}
+	}
+
 	act := &Activity{
-		metricType:  s.MetricType,
-		metricName:  s.MetricName,
-		includeHelp: s.IncludeHelp,
-		includeType: s.IncludeType,
-		timestamp:   s.Timestamp,
+		metricType:           s.MetricType,
+		metricName:           s.MetricName,
+		includeHelp:          s.IncludeHelp,
+		includeType:          s.IncludeType,
+		timestamp:            s.Timestamp,
+		exemplarField:        s.ExemplarLabelField,
+		exemplarLabelName:    s.ExemplarLabelName,
+		outputFormat:         s.OutputFormat,
+		statsdAddress:        s.StatsdAddress,
+		influxPrecision:      s.InfluxPrecision,
+		otlpEndpoint:         s.OTLPEndpoint,
+		otlpProtocol:         s.OTLPProtocol,
+		otlpHeaders:          otlpHeaders,
+		resourceAttributes:   resourceAttributes,
+		startTime:            time.Now(),
+		derivedMetrics:       derivedMetrics,
+		nameEscaping:         s.NameEscaping,
+		maxLabelValueLength:  s.MaxLabelValueLength,
+		nullHandling:         s.NullHandling,
+		selfMetrics:          s.SelfMetrics,
+		selfMetricsNamespace: s.SelfMetricsNamespace,
+		temporality:          s.Temporality,
+		firstSeen:            s.FirstSeen,
+		tracker: temporalityTracker{
+			ttl:       time.Duration(s.SeriesTTL) * time.Second,
+			maxSeries: s.MaxSeries,
+		},
+		labelNormalization: s.LabelNormalization,
+		labelPrefix:        s.LabelPrefix,
+		infoMetrics:        s.InfoMetrics,
+		reservedFields:     s.ReservedFields,
+		extraReserved:      extraReserved,
 	}
 	return act, nil
 }
@@ -64,6 +297,9 @@ func New(ctx activity.InitContext) (activity.Activity, error) {
 func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	logger := ctx.Logger()
 
+	// Every call is counted, including the ones that end early
+	a.stats.evals.Add(1)
+
 	// --- 1. Get Inputs ---
 	input := &Input{}
 	err = ctx.GetInputObject(input)
@@ -80,25 +316,92 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 	logger.Debugf("Input metric data: %+v", input.MetricData)
 	logger.Debugf("Processing %d fields in metric data", len(input.MetricData))
 
-	prometheusMetric, err := a.convertToPrometheusFormat(input.MetricData)
+	start := time.Now()
+	result, err := a.convertToPrometheusFormat(input.MetricData)
+	if err == nil {
+		a.applyTemporality(result, logger)
+	}
+	a.stats.recordConversion(result, time.Since(start))
 	if err != nil {
 		logger.Errorf("Failed to convert JSON to Prometheus format: %v", err)
 		return false, err
 	}
+	if result.FailedObjects > 0 {
+		logger.Warnf("Skipped %d metric objects that could not be converted", result.FailedObjects)
+	}
+	if dropped := result.dropOversizedExemplars(); dropped > 0 {
+		logger.Warnf("Dropped the exemplars of %d samples whose labels exceed %d runes", dropped, maxExemplarLabelRunes)
+	}
+	for _, collision := range result.Collisions {
+		if collision.Kind == "label" && collision.Name == "name" {
+			logger.Warnf("Source keys %v collide with the name label and are dropped", collision.Keys)
+			continue
+		}
+		logger.Warnf("Source keys %v all map to %s name '%s'; only '%s' is used", collision.Keys, collision.Kind, collision.Name, collision.Keys[0])
+	}
 
-	// Debug: Check the output before setting
-	logger.Debugf("DEBUG: prometheusMetric before setting: '%s'", prometheusMetric)
-	logger.Debugf("DEBUG: prometheusMetric contains newlines: %t", strings.Contains(prometheusMetric, "\n"))
-	logger.Debugf("DEBUG: prometheusMetric line count: %d", strings.Count(prometheusMetric, "\n")+1)
-
-	// Generate formatted version (multi-line) from single-line output
-	prometheusMetricFormatted := a.formatForLogging(prometheusMetric)
+	prometheusMetric := a.renderExposition(result)
+	exposed := result
+	if a.selfMetrics {
+		// The activity's own metrics are only appended to the exposition
+		// text; other encoders would misread cumulative totals
+		self := &conversionResult{Families: a.selfMetricFamilies(time.Now().UnixMilli())}
+		if prometheusMetric != "" {
+			prometheusMetric += "\n"
+		}
+		prometheusMetric += a.renderExposition(self)
+		exposed = &conversionResult{Families: append(append([]*metricFamily{}, result.Families...), self.Families...)}
+	}
+	logger.Debugf("Generated prometheus metric output: %s", prometheusMetric)
 
-	logger.Debugf("Generated prometheus metric output: %s", prometheusMetricFormatted)
+	formattedMetrics := prometheusMetric
+	if a.outputFormat == formatOpenMetrics {
+		formattedMetrics = a.renderOpenMetrics(exposed)
+	}
+	if a.outputFormat == formatInflux {
+		formattedMetrics = strings.Join(a.renderInflux(result), "\n")
+	}
+	if a.outputFormat == formatOTLP || a.otlpEndpoint != "" {
+		otlpData := a.buildOTLP(result)
+		if a.outputFormat == formatOTLP {
+			otlpJSON, err := marshalOTLPJSON(otlpData)
+			if err != nil {
+				logger.Errorf("Failed to encode OTLP metrics: %v", err)
+				return false, err
+			}
+			formattedMetrics = string(otlpJSON)
+		}
+		if a.otlpEndpoint != "" {
+			err = pushOTLP(a.otlpEndpoint, a.otlpProtocol, a.otlpHeaders, otlpData)
+			if err != nil {
+				logger.Errorf("Failed to push metrics to OTLP collector: %v", err)
+				return false, err
+			}
+			logger.Debugf("Pushed %d series to OTLP endpoint %s", result.seriesCount(), a.otlpEndpoint)
+		}
+	}
+	if a.outputFormat == formatDogStatsD || a.statsdAddress != "" {
+		statsdLines := a.renderStatsd(result)
+		if a.outputFormat == formatDogStatsD {
+			formattedMetrics = strings.Join(statsdLines, "\n")
+		}
+		if a.statsdAddress != "" {
+			err = sendStatsd(a.statsdAddress, statsdLines)
+			if err != nil {
+				logger.Errorf("Failed to send metrics to StatsD: %v", err)
+				return false, err
+			}
+			logger.Debugf("Sent %d metrics to StatsD agent at %s", len(statsdLines), a.statsdAddress)
+		}
+	}
 
 	// --- 3. Set Output ---
 	output := &Output{
-		PrometheusMetric: prometheusMetricFormatted,
+		PrometheusMetric: prometheusMetric,
+		FormattedMetrics: formattedMetrics,
+		MetricFamilies:   result.toStructured(),
+		SeriesCount:      result.seriesCount(),
+		SkippedFields:    result.SkippedFields,
 	}
 
 	err = ctx.SetOutputObject(output)
@@ -107,40 +410,43 @@ func (a *Activity) Eval(ctx activity.Context) (done bool, err error) {
 		return false, err
 	}
 
-	logger.Debugf("Successfully generated Prometheus metrics. Output length: %d, lines: %d",
-		len(prometheusMetric), strings.Count(prometheusMetric, "\n")+1)
-
-	// Additional debug logging to verify the output contains all lines
-	lines := strings.Split(prometheusMetric, "\n")
-	logger.Debugf("Final output contains %d lines", len(lines))
-	for i, line := range lines {
-		if strings.TrimSpace(line) != "" {
-			logger.Debugf("Output line %d: %s", i+1, line)
-		}
-	}
+	logger.Debugf("Successfully generated Prometheus metrics. Output length: %d, lines: %d, series: %d, skipped fields: %d",
+		len(prometheusMetric), strings.Count(prometheusMetric, "\n")+1, output.SeriesCount, output.SkippedFields)
 
 	logger.Debugf("Successfully converted JSON to Prometheus metric: %s", prometheusMetric)
 	return true, nil
 }
 
-// convertToPrometheusFormat converts JSON data to Prometheus metric format
-func (a *Activity) convertToPrometheusFormat(data map[string]interface{}) (string, error) {
-	var parts []string
-
-	// Add HELP comment if enabled
-	if a.includeHelp {
-		help := "Generated metric from JSON data"
-		if helpValue, ok := data["help"]; ok {
-			if helpStr, err := coerce.ToString(helpValue); err == nil {
-				help = helpStr
-			}
+// convertToPrometheusFormat converts JSON data into the internal metric model
+func (a *Activity) convertToPrometheusFormat(data map[string]interface{}) (*conversionResult, error) {
+	help := "Generated metric from JSON data"
+	if helpValue, ok := data[a.reservedKey("help")]; ok {
+		if helpStr, err := coerce.ToString(helpValue); err == nil {
+			help = helpStr
 		}
-		parts = append(parts, fmt.Sprintf("# HELP %s %s", a.metricName, help))
 	}
 
-	// Add TYPE comment if enabled
-	if a.includeType {
-		parts = append(parts, fmt.Sprintf("# TYPE %s %s", a.metricName, a.metricType))
+	family := &metricFamily{
+		Name: a.sanitizeMetricName(a.metricName),
+		Help: help,
+		Type: a.metricType,
+	}
+	result := &conversionResult{Families: []*metricFamily{family}}
+
+	// Info samples go to their own gauge family
+	infoFamily := &metricFamily{
+		Name: a.infoMetricName(),
+		Help: help,
+		Type: "gauge",
+	}
+	addSamples := func(samples []metricSample) {
+		for _, sample := range samples {
+			if sample.Name == infoFamily.Name && sample.Field == "" {
+				infoFamily.Samples = append(infoFamily.Samples, sample)
+			} else {
+				family.Samples = append(family.Samples, sample)
+			}
+		}
 	}
 
 	// Check if data contains an array of metrics
@@ -149,83 +455,189 @@ func (a *Activity) convertToPrometheusFormat(data map[string]interface{}) (strin
 		if metrics, err := coerce.ToArray(metricsArray); err == nil {
 			for _, metricItem := range metrics {
 				if metricObj, err := coerce.ToObject(metricItem); err == nil {
-					metricLine, err := a.processMetricObject(metricObj)
+					samples, skipped, collisions, err := a.processMetricObject(metricObj)
 					if err != nil {
+						result.FailedObjects++
 						continue // Skip invalid metric objects
 					}
-					parts = append(parts, metricLine)
+					addSamples(samples)
+					result.SkippedFields += skipped
+					result.Collisions = append(result.Collisions, collisions...)
 				}
 			}
 		}
 	} else {
 		// Handle single metric object (backward compatibility)
-		metricLine, err := a.processMetricObject(data)
+		samples, skipped, collisions, err := a.processMetricObject(data)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		parts = append(parts, metricLine)
+		addSamples(samples)
+		result.SkippedFields += skipped
+		result.Collisions = collisions
 	}
 
-	// Join all parts with spaces to create a single line
-	finalOutput := strings.Join(parts, " ")
+	if len(infoFamily.Samples) > 0 {
+		if len(family.Samples) == 0 {
+			result.Families = result.Families[:0]
+		}
+		result.Families = append(result.Families, infoFamily)
+	}
 
-	return finalOutput, nil
+	return result, nil
 }
 
-// formatForLogging converts single-line format back to multi-line for better log readability
-func (a *Activity) formatForLogging(singleLineOutput string) string {
-	// Split the single line back into logical components
-	// Look for patterns: "# HELP", "# TYPE", and metric lines
+// renderExposition renders the metric model in the Prometheus text
+// exposition format, one HELP/TYPE comment or sample per line. The format
+// (version 0.0.4) has no exemplars; they are only written by
+// renderOpenMetrics.
+func (a *Activity) renderExposition(result *conversionResult) string {
+	var lines []string
+
+	for _, family := range result.Families {
+		// Add HELP comment if enabled
+		if a.includeHelp {
+			lines = append(lines, fmt.Sprintf("# HELP %s %s", a.formatName(family.Name, true), escapeHelp(family.Help)))
+		}
 
-	formatted := singleLineOutput
+		// Add TYPE comment if enabled
+		if a.includeType {
+			lines = append(lines, fmt.Sprintf("# TYPE %s %s", a.formatName(family.Name, true), family.Type))
+		}
 
-	// Add newlines after HELP comment
-	formatted = strings.ReplaceAll(formatted, "# HELP ", "\n# HELP ")
+		for _, sample := range family.Samples {
+			lines = append(lines, a.formatSample(sample))
+		}
+	}
 
-	// Add newlines after TYPE comment
-	formatted = strings.ReplaceAll(formatted, "# TYPE ", "\n# TYPE ")
+	return strings.Join(lines, "\n")
+}
 
-	// Add newlines before each metric line (looking for metric_name pattern)
-	// This regex finds the start of each new metric line
-	parts := strings.Split(formatted, a.metricName+"{")
-	if len(parts) > 1 {
-		// Rejoin with newlines between metric lines
-		result := parts[0] + a.metricName + "{"
-		for i := 1; i < len(parts); i++ {
-			if i == 1 {
-				result += parts[i]
-			} else {
-				result += "\n" + a.metricName + "{" + parts[i]
-			}
+// formatSample renders a single sample line, including the optional
+// timestamp
+func (a *Activity) formatSample(sample metricSample) string {
+	line := a.formatSeries(sample.Name, sample.Labels) + " " + formatValue(sample.Value)
+
+	// Add timestamp if enabled
+	if a.timestamp {
+		line += " " + strconv.FormatInt(sample.Timestamp, 10)
+	}
+
+	return line
+}
+
+// formatSeries renders the series name and labels of a sample line
+func (a *Activity) formatSeries(name string, labels []labelPair) string {
+	if isValidLegacyName(name, true) {
+		if len(labels) == 0 {
+			return name
 		}
-		formatted = result
+		return name + "{" + a.formatLabels(labels) + "}"
+	}
+
+	// UTF-8 metric names move inside the braces as a quoted string
+	if len(labels) == 0 {
+		return "{" + a.formatName(name, true) + "}"
 	}
+	return "{" + a.formatName(name, true) + "," + a.formatLabels(labels) + "}"
+}
+
+// renderOpenMetrics renders the metric model in the OpenMetrics text format.
+// It differs from the Prometheus text format in what a scraper or
+// Pushgateway accepts: counter samples end in _total and histogram samples
+// are _bucket samples, timestamps are in seconds, exemplars follow the
+// counter and bucket samples, and the output ends with # EOF.
+func (a *Activity) renderOpenMetrics(result *conversionResult) string {
+	var lines []string
+
+	for _, family := range result.Families {
+		name := family.Name
+		if family.Type == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
 
-	// Clean up any leading newlines
-	formatted = strings.TrimPrefix(formatted, "\n")
+		if a.includeHelp {
+			lines = append(lines, fmt.Sprintf("# HELP %s %s", a.formatName(name, true), escapeOpenMetricsHelp(family.Help)))
+		}
+		// The type is always written, the sample names depend on it
+		lines = append(lines, fmt.Sprintf("# TYPE %s %s", a.formatName(name, true), family.Type))
+
+		for _, sample := range family.Samples {
+			lines = append(lines, a.formatOpenMetricsSample(family.Type, sample))
+		}
+	}
 
-	return formatted
+	return strings.Join(append(lines, "# EOF"), "\n")
 }
 
-// processMetricObject processes a single metric object and returns a Prometheus metric line
-func (a *Activity) processMetricObject(metricObj map[string]interface{}) (string, error) {
-	// Get timestamp once if enabled
-	var timestamp int64
-	if a.timestamp {
-		timestamp = time.Now().UnixMilli()
-		if timestampValue, ok := metricObj["timestamp"]; ok {
-			if ts, err := coerce.ToInt64(timestampValue); err == nil {
-				timestamp = ts
-			} else if tsStr, err := coerce.ToString(timestampValue); err == nil {
-				if t, err := time.Parse(time.RFC3339, tsStr); err == nil {
-					timestamp = t.UnixMilli()
-				}
+// formatOpenMetricsSample renders a single OpenMetrics sample line. A
+// histogram sample without an le label is a single +Inf bucket.
+func (a *Activity) formatOpenMetricsSample(metricType string, sample metricSample) string {
+	name, labels := sample.Name, sample.Labels
+	switch metricType {
+	case "counter":
+		name = strings.TrimSuffix(name, "_total") + "_total"
+	case "histogram":
+		if !strings.HasSuffix(name, "_sum") && !strings.HasSuffix(name, "_count") {
+			name = strings.TrimSuffix(name, "_bucket") + "_bucket"
+			if !hasLabel(labels, "le") {
+				labels = append(labels[:len(labels):len(labels)], labelPair{Name: "le", Value: "+Inf"})
 			}
 		}
 	}
 
+	line := a.formatSeries(name, labels) + " " + formatValue(sample.Value)
+	if a.timestamp {
+		line += " " + formatSeconds(sample.Timestamp)
+	}
+
+	// Exemplars are only allowed on counter and histogram bucket samples
+	if sample.Exemplar != nil && (strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_bucket")) {
+		line += " # {" + a.formatLabels(sample.Exemplar.Labels) + "} " +
+			formatValue(sample.Exemplar.Value) + " " + formatSeconds(sample.Exemplar.Timestamp)
+	}
+
+	return line
+}
+
+// hasLabel reports whether labels contain a label with the given name
+func hasLabel(labels []labelPair, name string) bool {
+	for _, label := range labels {
+		if label.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLabels renders label pairs as name="value" separated by commas
+func (a *Activity) formatLabels(labels []labelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, a.formatName(label.Name, false), a.sanitizeLabelValue(label.Value)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// formatValue renders a sample value the way Prometheus expects it, with
+// special values written as NaN, +Inf and -Inf. The text format cannot
+// distinguish a stale marker from any other NaN.
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// processMetricObject processes a single metric object into one sample per
+// numeric field. It also returns the number of fields that were neither
+// converted to a metric nor used as a label, and the source keys that were
+// merged because they normalized to the same label or metric name.
+func (a *Activity) processMetricObject(metricObj map[string]interface{}) ([]metricSample, int, []labelCollision, error) {
+	// Get timestamp once, used for the sample timestamp and exemplars
+	timestamp := a.resolveTimestamp(metricObj)
+
+	exemplar := a.buildExemplar(metricObj, timestamp)
+
 	// Process each numeric field as a separate metric
-	var metricLines []string
+	var samples []metricSample
 
 	// Get all keys and sort them for consistent output
 	keys := make([]string, 0, len(metricObj))
@@ -235,10 +647,38 @@ func (a *Activity) processMetricObject(metricObj map[string]interface{}) (string
 	sort.Strings(keys)
 
 	// Extract labels (all non-numeric, non-reserved fields)
-	labels := a.extractLabelsFromObject(metricObj)
+	labels, collisions := a.extractLabelsFromObject(metricObj)
+	skippedFields := 0
+	for _, collision := range collisions {
+		// Keys that collide with the name label are all dropped
+		skippedFields += len(collision.Keys)
+		if collision.Name != "name" {
+			skippedFields--
+		}
+	}
+
+	// Fields whose normalized names collide would produce duplicate series;
+	// the first key in sorted order wins
+	fieldSources := make(map[string]string)
+	fieldCollisions := make(map[string]int)
+	addSample := func(key string, value float64) {
+		field := a.normalizeKey(key)
+		if first, exists := fieldSources[field]; exists {
+			i, reported := fieldCollisions[field]
+			if !reported {
+				collisions = append(collisions, labelCollision{Kind: "metric", Name: field, Keys: []string{first}})
+				i = len(collisions) - 1
+				fieldCollisions[field] = i
+			}
+			collisions[i].Keys = append(collisions[i].Keys, key)
+			skippedFields++
+			return
+		}
+		fieldSources[field] = key
+		samples = append(samples, a.newSample(field, value, labels, timestamp, exemplar))
+	}
 
 	// Process each numeric field
-	numericFieldsFound := 0
 	for _, key := range keys {
 		val := metricObj[key]
 
@@ -247,68 +687,232 @@ func (a *Activity) processMetricObject(metricObj map[string]interface{}) (string
 			continue
 		}
 
-		// Check if this field is numeric
-		var value string
-		if floatVal, err := coerce.ToFloat64(val); err == nil {
-			value = strconv.FormatFloat(floatVal, 'f', -1, 64)
-		} else if intVal, err := coerce.ToInt64(val); err == nil {
-			value = strconv.FormatInt(intVal, 10)
-		} else if strVal, err := coerce.ToString(val); err == nil {
-			// Try to parse string as number
-			if floatVal, err := strconv.ParseFloat(strVal, 64); err == nil {
-				value = strconv.FormatFloat(floatVal, 'f', -1, 64)
-			} else if intVal, err := strconv.ParseInt(strVal, 10, 64); err == nil {
-				value = strconv.FormatInt(intVal, 10)
+		// JSON null is skipped, or reported as a stale marker, which OTLP
+		// and the structured output carry and the text formats write as NaN
+		if val == nil {
+			if a.nullHandling == nullStale {
+				addSample(key, staleNaN())
 			} else {
-				// Not a numeric value, skip
-				continue
+				skippedFields++
 			}
-		} else {
-			// Not a numeric value, skip
 			continue
 		}
 
-		numericFieldsFound++
-
-		// Build metric line
-		metricLine := a.metricName
-
-		// Add name label to distinguish different metrics (use "name" instead of "metric_name")
-		allLabels := fmt.Sprintf(`name="%s"`, a.sanitizeLabelValue(key))
-		if len(labels) > 0 {
-			allLabels += "," + labels
+		// Check if this field is numeric
+		value, ok := numericValue(val)
+		if !ok {
+			if isStructuredValue(val) {
+				skippedFields++
+			}
+			continue
 		}
 
-		if len(allLabels) > 0 {
-			metricLine += "{" + allLabels + "}"
+		addSample(key, value)
+	}
+
+	// Derived series are computed from the fields of the same object. A
+	// definition that cannot be evaluated (missing field, division by zero)
+	// is skipped for this object only.
+	for _, derived := range a.derivedMetrics {
+		if _, exists := metricObj[derived.Name]; exists {
+			skippedFields++
+			continue
 		}
-		metricLine += " " + value // Add timestamp if enabled
-		if a.timestamp {
-			metricLine += " " + strconv.FormatInt(timestamp, 10)
+		value, err := derived.Expr.eval(metricObj)
+		if err != nil {
+			skippedFields++
+			continue
 		}
+		addSample(derived.Name, value)
+	}
 
-		metricLines = append(metricLines, metricLine)
+	// An object that only describes something (build info, device
+	// metadata) becomes a single info sample carrying its labels
+	if len(samples) == 0 && a.infoMetrics && len(labels) > 0 {
+		info := metricSample{
+			Name:      a.infoMetricName(),
+			Labels:    labels,
+			Value:     1,
+			Timestamp: timestamp,
+		}
+		return []metricSample{info}, skippedFields, collisions, nil
 	}
 
-	if len(metricLines) == 0 {
+	if len(samples) == 0 {
 		// Create a list of available keys for debugging
 		var availableKeys []string
 		for k := range metricObj {
 			availableKeys = append(availableKeys, k)
 		}
-		return "", fmt.Errorf("no numeric value found in metric object. Available fields: %v", availableKeys)
+		return nil, 0, nil, fmt.Errorf("no numeric value found in metric object. Available fields: %v", availableKeys)
+	}
+
+	return samples, skippedFields, collisions, nil
+}
+
+// infoMetricName returns the name of the info metric, <metricName>_info
+func (a *Activity) infoMetricName() string {
+	name := a.sanitizeMetricName(a.metricName)
+	if strings.HasSuffix(name, "_info") {
+		return name
 	}
+	return name + "_info"
+}
 
-	// Join all metric lines into a single line separated by spaces instead of newlines
-	finalResult := strings.Join(metricLines, " ")
+// newSample builds the sample for one field of a metric object
+func (a *Activity) newSample(field string, value float64, labels []labelPair, timestamp int64, exemplar *metricExemplar) metricSample {
+	// Add name label to distinguish different metrics (use "name" instead of "metric_name")
+	sampleLabels := make([]labelPair, 0, len(labels)+1)
+	sampleLabels = append(sampleLabels, labelPair{Name: nameLabel, Value: a.truncateLabelValue(field)})
+	sampleLabels = append(sampleLabels, labels...)
+
+	sample := metricSample{
+		Name:      a.sanitizeMetricName(a.metricName),
+		Field:     field,
+		Labels:    sampleLabels,
+		Value:     value,
+		Timestamp: timestamp,
+	}
+	if exemplar != nil {
+		sample.Exemplar = &metricExemplar{
+			Labels:    exemplar.Labels,
+			Value:     value,
+			Timestamp: exemplar.Timestamp,
+		}
+	}
+	return sample
+}
 
-	// Ensure we return properly formatted string as single line
-	return finalResult, nil
+// numericValue returns the numeric value of a field, accepting numbers and
+// strings that parse as numbers
+func numericValue(val interface{}) (float64, bool) {
+	if val == nil || isStructuredValue(val) {
+		return 0, false
+	}
+	if strVal, ok := val.(string); ok {
+		if special, ok := parseSpecialFloat(strVal); ok {
+			return special, true
+		}
+	}
+	if floatVal, err := coerce.ToFloat64(val); err == nil {
+		return floatVal, true
+	} else if intVal, err := coerce.ToInt64(val); err == nil {
+		return float64(intVal), true
+	} else if strVal, err := coerce.ToString(val); err == nil {
+		// Try to parse string as number
+		if floatVal, err := strconv.ParseFloat(strVal, 64); err == nil {
+			return floatVal, true
+		} else if intVal, err := strconv.ParseInt(strVal, 10, 64); err == nil {
+			return float64(intVal), true
+		}
+	}
+	// Not a numeric value
+	return 0, false
 }
 
-// extractLabelsFromObject extracts and formats labels from a metric object
-func (a *Activity) extractLabelsFromObject(metricObj map[string]interface{}) string {
-	var labelPairs []string
+// specialFloats are the accepted spellings of NaN and the infinities,
+// matched case-insensitively
+var specialFloats = map[string]float64{
+	"nan":       math.NaN(),
+	"+nan":      math.NaN(),
+	"-nan":      math.NaN(),
+	"inf":       math.Inf(1),
+	"+inf":      math.Inf(1),
+	"infinity":  math.Inf(1),
+	"+infinity": math.Inf(1),
+	"-inf":      math.Inf(-1),
+	"-infinity": math.Inf(-1),
+}
+
+// parseSpecialFloat parses textual NaN and infinity values such as "NaN",
+// "+Inf" or "-Infinity"
+func parseSpecialFloat(s string) (float64, bool) {
+	value, ok := specialFloats[strings.ToLower(strings.TrimSpace(s))]
+	return value, ok
+}
+
+// staleNaNBits is the NaN bit pattern Prometheus uses as a staleness marker
+const staleNaNBits uint64 = 0x7ff0000000000002
+
+// staleNaN returns the Prometheus staleness marker value
+func staleNaN() float64 {
+	return math.Float64frombits(staleNaNBits)
+}
+
+// isStaleNaN reports whether value is the Prometheus staleness marker
+func isStaleNaN(value float64) bool {
+	return math.Float64bits(value) == staleNaNBits
+}
+
+// isStructuredValue reports whether a field holds a nested object or array,
+// which can be neither a metric value nor a label
+func isStructuredValue(val interface{}) bool {
+	switch val.(type) {
+	case map[string]interface{}, []interface{}, []map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// resolveTimestamp returns the metric object's timestamp in milliseconds,
+// falling back to the current time when it is missing or unparsable
+func (a *Activity) resolveTimestamp(metricObj map[string]interface{}) int64 {
+	timestamp := time.Now().UnixMilli()
+	if timestampValue, ok := metricObj[a.reservedKey("timestamp")]; ok {
+		if ts, err := coerce.ToInt64(timestampValue); err == nil {
+			timestamp = ts
+		} else if tsStr, err := coerce.ToString(timestampValue); err == nil {
+			if t, err := time.Parse(time.RFC3339, tsStr); err == nil {
+				timestamp = t.UnixMilli()
+			}
+		}
+	}
+	return timestamp
+}
+
+// buildExemplar returns the exemplar (e.g. {trace_id="abc"}) for a metric
+// object, or nil when exemplars do not apply to it. OpenMetrics only allows
+// exemplars on counter and histogram bucket samples.
+func (a *Activity) buildExemplar(metricObj map[string]interface{}, timestamp int64) *metricExemplar {
+	if a.exemplarField == "" || (a.metricType != "counter" && a.metricType != "histogram") {
+		return nil
+	}
+
+	val, ok := metricObj[a.exemplarField]
+	if !ok || val == nil {
+		return nil
+	}
+	labelValue, err := coerce.ToString(val)
+	if err != nil || labelValue == "" {
+		return nil
+	}
+
+	labelName := a.exemplarLabelName
+	if labelName == "" {
+		labelName = "trace_id"
+	}
+	return &metricExemplar{
+		Labels:    []labelPair{{Name: labelName, Value: labelValue}},
+		Timestamp: timestamp,
+	}
+}
+
+// formatSeconds renders a millisecond timestamp as OpenMetrics seconds with
+// millisecond precision
+func formatSeconds(timestamp int64) string {
+	return strconv.FormatFloat(float64(timestamp)/1000, 'f', 3, 64)
+}
+
+// extractLabelsFromObject extracts labels from a metric object. Label names
+// are sanitized here; values are escaped when the sample is rendered.
+func (a *Activity) extractLabelsFromObject(metricObj map[string]interface{}) ([]labelPair, []labelCollision) {
+	var labels []labelPair
+	var collisions []labelCollision
+
+	// The name label is always present, so a key normalizing to "name"
+	// collides with it
+	sources := map[string]string{"name": ""}
+	reported := make(map[string]int)
 
 	// Get all keys and sort them for consistent output
 	keys := make([]string, 0, len(metricObj))
@@ -320,77 +924,142 @@ func (a *Activity) extractLabelsFromObject(metricObj map[string]interface{}) str
 	for _, key := range keys {
 		val := metricObj[key]
 
-		// Skip reserved fields
-		if a.isReservedField(key) {
+		// Skip reserved fields, nulls and nested objects or arrays
+		if a.isReservedField(key) || val == nil || isStructuredValue(val) {
 			continue
 		}
 
 		// Only include non-numeric string values as labels
 		if strVal, err := coerce.ToString(val); err == nil {
-			// Check if this value can be parsed as a number
-			isNumeric := false
-			if _, err := strconv.ParseFloat(strVal, 64); err == nil {
-				isNumeric = true
-			} else if _, err := strconv.ParseInt(strVal, 10, 64); err == nil {
-				isNumeric = true
-			} else if _, err := coerce.ToFloat64(val); err == nil {
-				isNumeric = true
-			} else if _, err := coerce.ToInt64(val); err == nil {
-				isNumeric = true
-			}
-
-			// Only add as label if it's not numeric
-			if !isNumeric {
-				labelPairs = append(labelPairs, fmt.Sprintf("%s=\"%s\"", a.sanitizeLabelName(key), a.sanitizeLabelValue(strVal)))
+			if _, isNumeric := numericValue(val); !isNumeric {
+				name := a.normalizeLabelName(key)
+				if first, exists := sources[name]; exists {
+					i, ok := reported[name]
+					if !ok {
+						var keys []string
+						if first != "" {
+							keys = append(keys, first)
+						}
+						collisions = append(collisions, labelCollision{Kind: "label", Name: name, Keys: keys})
+						i = len(collisions) - 1
+						reported[name] = i
+					}
+					collisions[i].Keys = append(collisions[i].Keys, key)
+					continue
+				}
+				sources[name] = key
+				labels = append(labels, labelPair{Name: name, Value: a.truncateLabelValue(strVal)})
 			}
 		}
 	}
 
-	return strings.Join(labelPairs, ",")
+	return labels, collisions
 }
 
-// sanitizeLabelValue escapes special characters in label values
+// sanitizeLabelValue escapes special characters in label values following
+// the text exposition format: backslash, double quote and line feed are
+// escaped. Other control characters have no escape sequence and are replaced
+// by a space, and invalid UTF-8 by U+FFFD.
 func (a *Activity) sanitizeLabelValue(value string) string {
-	// Escape quotes and backslashes in label values
-	escapedVal := strings.ReplaceAll(value, "\\", "\\\\")
-	escapedVal = strings.ReplaceAll(escapedVal, "\"", "\\\"")
-	return escapedVal
+	var escaped strings.Builder
+
+	for _, r := range strings.ToValidUTF8(value, "\uFFFD") {
+		switch {
+		case r == '\\':
+			escaped.WriteString(`\\`)
+		case r == '"':
+			escaped.WriteString(`\"`)
+		case r == '\n':
+			escaped.WriteString(`\n`)
+		case r != '\t' && unicode.IsControl(r):
+			escaped.WriteRune(' ')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+
+	return escaped.String()
+}
+
+// escapeHelp escapes HELP text, where only backslash and line feed have
+// escape sequences
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// replaceControlChars replaces the control characters in s, which encoders
+// without an escape for them cannot carry
+func replaceControlChars(s, replacement string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	var replaced strings.Builder
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			replaced.WriteString(replacement)
+		} else {
+			replaced.WriteRune(r)
+		}
+	}
+	return replaced.String()
+}
+
+// escapeOpenMetricsHelp escapes HELP text in OpenMetrics, which also escapes
+// double quotes
+func escapeOpenMetricsHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(help)
+}
+
+// truncateLabelValue shortens a label value to the configured maximum
+// number of characters. Zero means unlimited.
+func (a *Activity) truncateLabelValue(value string) string {
+	if a.maxLabelValueLength <= 0 || utf8.RuneCountInString(value) <= a.maxLabelValueLength {
+		return value
+	}
+	return string([]rune(value)[:a.maxLabelValueLength])
+}
+
+// reservedKey returns the key a reserved field (help, timestamp, type) is
+// read from: the plain name in legacy mode, prefixed with an underscore in
+// namespaced mode
+func (a *Activity) reservedKey(name string) string {
+	if a.reservedFields == reservedNamespaced {
+		return "_" + name
+	}
+	return name
 }
 
 // isReservedField checks if a field is reserved and should not be used as a label
 func (a *Activity) isReservedField(key string) bool {
 	reservedFields := map[string]bool{
-		"help":      true,
-		"timestamp": true,
-		"type":      true,
+		a.reservedKey("help"):      true,
+		a.reservedKey("timestamp"): true,
+		a.reservedKey("type"):      true,
+	}
+	// The exemplar source field carries high-cardinality values such as
+	// trace IDs and must never become a label or a metric
+	if a.exemplarField != "" && key == a.exemplarField {
+		return true
+	}
+	for _, field := range a.extraReserved {
+		if key == field {
+			return true
+		}
 	}
 	return reservedFields[strings.ToLower(key)]
 }
 
 // sanitizeLabelName ensures label names conform to Prometheus requirements
+// using the configured escaping scheme
 func (a *Activity) sanitizeLabelName(name string) string {
-	// Prometheus label names must match [a-zA-Z_][a-zA-Z0-9_]*
-	var result strings.Builder
-
-	for i, r := range name {
-		if i == 0 {
-			// First character must be letter or underscore
-			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' {
-				result.WriteRune(r)
-			} else {
-				result.WriteRune('_')
-			}
-		} else {
-			// Subsequent characters can be letters, digits, or underscores
-			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
-				result.WriteRune(r)
-			} else {
-				result.WriteRune('_')
-			}
-		}
-	}
+	// Prometheus label names must match [a-zA-Z_][a-zA-Z0-9_]* unless UTF-8
+	// names are allowed
+	return escapeName(name, a.nameEscaping, false)
+}
 
-	return result.String()
+// isValidLabelName reports whether name already matches [a-zA-Z_][a-zA-Z0-9_]*
+func isValidLabelName(name string) bool {
+	return isValidLegacyName(name, false)
 }
 
 // --- Supporting Structs ---
@@ -401,6 +1070,31 @@ type Settings struct {
 	IncludeHelp bool   `md:"includeHelp"`
 	IncludeType bool   `md:"includeType"`
 	Timestamp   bool   `md:"timestamp"`
+
+	ExemplarLabelField   string `md:"exemplarLabelField"`
+	ExemplarLabelName    string `md:"exemplarLabelName"`
+	OutputFormat         string `md:"outputFormat"`
+	StatsdAddress        string `md:"statsdAddress"`
+	InfluxPrecision      string `md:"influxPrecision"`
+	OTLPEndpoint         string `md:"otlpEndpoint"`
+	OTLPProtocol         string `md:"otlpProtocol"`
+	OTLPHeaders          string `md:"otlpHeaders"`
+	ResourceAttributes   string `md:"resourceAttributes"`
+	DerivedMetrics       string `md:"derivedMetrics"`
+	NameEscaping         string `md:"nameEscaping"`
+	MaxLabelValueLength  int    `md:"maxLabelValueLength"`
+	NullHandling         string `md:"nullHandling"`
+	SelfMetrics          bool   `md:"selfMetrics"`
+	SelfMetricsNamespace string `md:"selfMetricsNamespace"`
+	Temporality          string `md:"temporality"`
+	FirstSeen            string `md:"firstSeen"`
+	SeriesTTL            int    `md:"seriesTTL"` // seconds
+	MaxSeries            int    `md:"maxSeries"`
+	LabelNormalization   string `md:"labelNormalization"`
+	LabelPrefix          string `md:"labelPrefix"`
+	InfoMetrics          bool   `md:"infoMetrics"`
+	ReservedFields       string `md:"reservedFields"`
+	ExtraReservedFields  string `md:"extraReservedFields"`
 }
 
 // FromMap populates the struct from a map.
@@ -411,6 +1105,19 @@ func (s *Settings) FromMap(values map[string]interface{}) error {
 		s.IncludeHelp = true
 		s.IncludeType = true
 		s.Timestamp = false
+		s.ExemplarLabelName = "trace_id"
+		s.OutputFormat = formatPrometheus
+		s.InfluxPrecision = "ns"
+		s.OTLPProtocol = otlpProtocolProtobuf
+		s.NameEscaping = escapingUnderscores
+		s.NullHandling = nullSkip
+		s.SelfMetricsNamespace = defaultSelfMetricsNamespace
+		s.Temporality = temporalityNone
+		s.FirstSeen = firstSeenDrop
+		s.SeriesTTL = defaultSeriesTTL
+		s.MaxSeries = defaultMaxSeries
+		s.LabelNormalization = normalizeNone
+		s.ReservedFields = reservedLegacy
 		return nil
 	}
 
@@ -463,6 +1170,210 @@ func (s *Settings) FromMap(values map[string]interface{}) error {
 		s.Timestamp = false // Default if not present
 	}
 
+	if val, ok := values[sExemplarLabelField]; ok && val != nil {
+		s.ExemplarLabelField, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sExemplarLabelName]; ok && val != nil {
+		s.ExemplarLabelName, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.ExemplarLabelName == "" {
+		s.ExemplarLabelName = "trace_id"
+	}
+
+	if val, ok := values[sOutputFormat]; ok && val != nil {
+		s.OutputFormat, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.OutputFormat == "" {
+		s.OutputFormat = formatPrometheus
+	}
+
+	if val, ok := values[sStatsdAddress]; ok && val != nil {
+		s.StatsdAddress, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sInfluxPrecision]; ok && val != nil {
+		s.InfluxPrecision, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.InfluxPrecision == "" {
+		s.InfluxPrecision = "ns"
+	}
+
+	if val, ok := values[sOTLPEndpoint]; ok && val != nil {
+		s.OTLPEndpoint, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sOTLPProtocol]; ok && val != nil {
+		s.OTLPProtocol, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.OTLPProtocol == "" {
+		s.OTLPProtocol = otlpProtocolProtobuf
+	}
+
+	if val, ok := values[sOTLPHeaders]; ok && val != nil {
+		s.OTLPHeaders, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sResourceAttributes]; ok && val != nil {
+		s.ResourceAttributes, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sDerivedMetrics]; ok && val != nil {
+		s.DerivedMetrics, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sNameEscaping]; ok && val != nil {
+		s.NameEscaping, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.NameEscaping == "" {
+		s.NameEscaping = escapingUnderscores
+	}
+
+	if val, ok := values[sMaxLabelValueLen]; ok && val != nil {
+		s.MaxLabelValueLength, err = coerce.ToInt(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sNullHandling]; ok && val != nil {
+		s.NullHandling, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.NullHandling == "" {
+		s.NullHandling = nullSkip
+	}
+
+	if val, ok := values[sSelfMetrics]; ok && val != nil {
+		s.SelfMetrics, err = coerce.ToBool(val)
+		if err != nil {
+			s.SelfMetrics = false // Default on error
+		}
+	}
+
+	if val, ok := values[sSelfMetricsNS]; ok && val != nil {
+		s.SelfMetricsNamespace, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.SelfMetricsNamespace == "" {
+		s.SelfMetricsNamespace = defaultSelfMetricsNamespace
+	}
+
+	if val, ok := values[sTemporality]; ok && val != nil {
+		s.Temporality, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Temporality == "" {
+		s.Temporality = temporalityNone
+	}
+
+	if val, ok := values[sFirstSeen]; ok && val != nil {
+		s.FirstSeen, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.FirstSeen == "" {
+		s.FirstSeen = firstSeenDrop
+	}
+
+	// Zero disables the limit, so the defaults apply only when unset
+	s.SeriesTTL = defaultSeriesTTL
+	if val, ok := values[sSeriesTTL]; ok && val != nil {
+		s.SeriesTTL, err = coerce.ToInt(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.MaxSeries = defaultMaxSeries
+	if val, ok := values[sMaxSeries]; ok && val != nil {
+		s.MaxSeries, err = coerce.ToInt(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sLabelNormalization]; ok && val != nil {
+		s.LabelNormalization, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.LabelNormalization == "" {
+		s.LabelNormalization = normalizeNone
+	}
+
+	if val, ok := values[sLabelPrefix]; ok && val != nil {
+		s.LabelPrefix, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := values[sInfoMetrics]; ok && val != nil {
+		s.InfoMetrics, err = coerce.ToBool(val)
+		if err != nil {
+			s.InfoMetrics = false // Default on error
+		}
+	}
+
+	if val, ok := values[sReservedFields]; ok && val != nil {
+		s.ReservedFields, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if s.ReservedFields == "" {
+		s.ReservedFields = reservedLegacy
+	}
+
+	if val, ok := values[sExtraReserved]; ok && val != nil {
+		s.ExtraReservedFields, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -492,13 +1403,21 @@ func (i *Input) ToMap() map[string]interface{} {
 }
 
 type Output struct {
-	PrometheusMetric string `md:"prometheusMetric"`
+	PrometheusMetric string        `md:"prometheusMetric"`
+	FormattedMetrics string        `md:"formattedMetrics"`
+	MetricFamilies   []interface{} `md:"metricFamilies"`
+	SeriesCount      int           `md:"seriesCount"`
+	SkippedFields    int           `md:"skippedFields"`
 }
 
 // ToMap converts the struct to a map.
 func (o *Output) ToMap() map[string]interface{} {
 	return map[string]interface{}{
 		"prometheusMetric": o.PrometheusMetric,
+		"formattedMetrics": o.FormattedMetrics,
+		"metricFamilies":   o.MetricFamilies,
+		"seriesCount":      o.SeriesCount,
+		"skippedFields":    o.SkippedFields,
 	}
 }
 
@@ -515,5 +1434,29 @@ func (o *Output) FromMap(values map[string]interface{}) error {
 			return err
 		}
 	}
+	if val, ok := values["formattedMetrics"]; ok && val != nil {
+		o.FormattedMetrics, err = coerce.ToString(val)
+		if err != nil {
+			return err
+		}
+	}
+	if val, ok := values["metricFamilies"]; ok && val != nil {
+		o.MetricFamilies, err = coerce.ToArray(val)
+		if err != nil {
+			return err
+		}
+	}
+	if val, ok := values["seriesCount"]; ok && val != nil {
+		o.SeriesCount, err = coerce.ToInt(val)
+		if err != nil {
+			return err
+		}
+	}
+	if val, ok := values["skippedFields"]; ok && val != nil {
+		o.SkippedFields, err = coerce.ToInt(val)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
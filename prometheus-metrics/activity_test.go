@@ -1,10 +1,14 @@
 package prometheusmetrics
 
 import (
+	"math"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/project-flogo/core/support/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestActivity_TempHumidityExample(t *testing.T) {
@@ -265,3 +269,447 @@ func TestSanitizeLabelName(t *testing.T) {
 		assert.Equal(t, test.expected, result, "Input: %s", test.input)
 	}
 }
+
+func TestActivity_Eval_Exemplar(t *testing.T) {
+	act := &Activity{
+		metricType:        "counter",
+		metricName:        "http_requests_total",
+		includeHelp:       false,
+		includeType:       false,
+		timestamp:         false,
+		exemplarField:     "traceId",
+		exemplarLabelName: "trace_id",
+		outputFormat:      formatOpenMetrics,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"requests":  42,
+			"service":   "checkout",
+			"traceId":   "4bf92f3577b34da6a3ce929d0e0e4736",
+			"timestamp": int64(1705316200000),
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	outputStr := tc.GetOutput("formattedMetrics").(string)
+	assert.Equal(t, "# TYPE http_requests counter\n"+
+		`http_requests_total{name="requests",service="checkout"} 42 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 42 1705316200.000`+
+		"\n# EOF", outputStr)
+	assert.NotContains(t, outputStr, `traceId=`)
+
+	// The Prometheus text format has no exemplars
+	assert.Equal(t, `http_requests_total{name="requests",service="checkout"} 42`, tc.GetOutput("prometheusMetric"))
+}
+
+func TestRenderOpenMetrics(t *testing.T) {
+	act := &Activity{includeHelp: true, includeType: false, timestamp: true}
+	result := &conversionResult{Families: []*metricFamily{
+		{Name: "jobs", Help: `Jobs "done"`, Type: "counter", Samples: []metricSample{
+			{Name: "jobs", Labels: []labelPair{{Name: "name", Value: "done"}}, Value: 3, Timestamp: 1705316200000},
+		}},
+		{Name: "latency", Help: "Latency", Type: "histogram", Samples: []metricSample{
+			{Name: "latency", Labels: []labelPair{{Name: "name", Value: "api"}}, Value: 7, Timestamp: 1705316200500,
+				Exemplar: &metricExemplar{Labels: []labelPair{{Name: "trace_id", Value: "abc"}}, Value: 7, Timestamp: 1705316200500}},
+		}},
+		{Name: "temp", Help: "Temperature", Type: "gauge", Samples: []metricSample{
+			{Name: "temp", Value: 21.5, Timestamp: 1705316200000},
+		}},
+	}}
+
+	assert.Equal(t, `# HELP jobs Jobs \"done\"
+# TYPE jobs counter
+jobs_total{name="done"} 3 1705316200.000
+# HELP latency Latency
+# TYPE latency histogram
+latency_bucket{name="api",le="+Inf"} 7 1705316200.500 # {trace_id="abc"} 7 1705316200.500
+# HELP temp Temperature
+# TYPE temp gauge
+temp 21.5 1705316200.000
+# EOF`, act.renderOpenMetrics(result))
+}
+
+func TestActivity_Eval_ExemplarIgnoredForGauge(t *testing.T) {
+	act := &Activity{
+		metricType:        "gauge",
+		metricName:        "cpu_usage",
+		exemplarField:     "traceId",
+		exemplarLabelName: "trace_id",
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"cpu":     75.2,
+			"traceId": "abc",
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Equal(t, `cpu_usage{name="cpu"} 75.2`, tc.GetOutput("prometheusMetric"))
+}
+
+func TestActivity_Eval_ExemplarTooLong(t *testing.T) {
+	for _, format := range []string{formatPrometheus, formatOpenMetrics} {
+		act := &Activity{
+			metricType:        "counter",
+			metricName:        "http_requests_total",
+			exemplarField:     "traceId",
+			exemplarLabelName: "trace_id",
+			outputFormat:      format,
+		}
+
+		// Only the oversized exemplar is dropped, not the sample
+		tc := test.NewActivityContext(act.Metadata())
+		tc.SetInputObject(&Input{
+			MetricData: map[string]interface{}{
+				"metrics": []interface{}{
+					map[string]interface{}{"requests": 1, "traceId": strings.Repeat("é", 121)},
+					map[string]interface{}{"requests": 2, "traceId": "abc"},
+				},
+			},
+		})
+
+		done, err := act.Eval(tc)
+		require.NoError(t, err, format)
+		assert.True(t, done)
+		assert.Equal(t, 2, tc.GetOutput("seriesCount"), format)
+
+		samples := tc.GetOutput("metricFamilies").([]interface{})[0].(map[string]interface{})["samples"].([]interface{})
+		assert.NotContains(t, samples[0], "exemplar", format)
+		assert.Contains(t, samples[1], "exemplar", format)
+	}
+}
+
+func TestActivity_Eval_StructuredOutput(t *testing.T) {
+	act := &Activity{
+		metricType:  "gauge",
+		metricName:  "system_metrics",
+		includeHelp: true,
+		includeType: true,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"cpu_usage":    75.2,
+			"memory_usage": 68.5,
+			"service":      "web-server",
+			"details":      map[string]interface{}{"zone": "a"},
+			"timestamp":    int64(1705316200000),
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := "# HELP system_metrics Generated metric from JSON data\n" +
+		"# TYPE system_metrics gauge\n" +
+		`system_metrics{name="cpu_usage",service="web-server"} 75.2` + "\n" +
+		`system_metrics{name="memory_usage",service="web-server"} 68.5`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+	assert.Equal(t, 2, tc.GetOutput("seriesCount"))
+	assert.Equal(t, 1, tc.GetOutput("skippedFields"))
+
+	families := tc.GetOutput("metricFamilies").([]interface{})
+	assert.Len(t, families, 1)
+
+	family := families[0].(map[string]interface{})
+	assert.Equal(t, "system_metrics", family["name"])
+	assert.Equal(t, "gauge", family["type"])
+
+	samples := family["samples"].([]interface{})
+	assert.Len(t, samples, 2)
+
+	sample := samples[0].(map[string]interface{})
+	assert.Equal(t, "cpu_usage", sample["field"])
+	assert.Equal(t, 75.2, sample["value"])
+	assert.Equal(t, int64(1705316200000), sample["timestamp"])
+	assert.Equal(t, map[string]interface{}{"name": "cpu_usage", "service": "web-server"}, sample["labels"])
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	act := &Activity{}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`plain`, `plain`},
+		{`C:\temp`, `C:\\temp`},
+		{`say "hi"`, `say \"hi\"`},
+		{"line1\nline2", `line1\nline2`},
+		{"col1\tcol2", "col1\tcol2"},
+		{"carriage\r\nreturn", `carriage \nreturn`},
+		{"bell\x07null\x00", "bell null "},
+		{"bad\xffutf8", "bad\uFFFDutf8"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, act.sanitizeLabelValue(test.input), "Input: %q", test.input)
+	}
+}
+
+func TestEscapeHelp(t *testing.T) {
+	assert.Equal(t, `Requests per "route"\nin C:\\app`, escapeHelp("Requests per \"route\"\nin C:\\app"))
+}
+
+func TestActivity_Eval_MultiLineLabelValue(t *testing.T) {
+	act := &Activity{
+		metricType:          "gauge",
+		metricName:          "job_status",
+		includeHelp:         true,
+		maxLabelValueLength: 13,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"exit_code": 1,
+			"error":     "timeout\nretrying in 5s",
+			"help":      "Job status\nper run",
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := `# HELP job_status Job status\nper run` + "\n" +
+		`job_status{name="exit_code",error="timeout\nretry"} 1`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+}
+
+func TestActivity_Eval_SpecialFloatValues(t *testing.T) {
+	act := &Activity{
+		metricType: "gauge",
+		metricName: "sensor",
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"a_nan":     "nan",
+			"b_inf":     "Infinity",
+			"c_neg_inf": "-inf",
+			"d_pos_inf": "+Inf",
+			"e_float":   math.Inf(-1),
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	output := tc.GetOutput("prometheusMetric").(string)
+	expected := `sensor{name="a_nan"} NaN` + "\n" +
+		`sensor{name="b_inf"} +Inf` + "\n" +
+		`sensor{name="c_neg_inf"} -Inf` + "\n" +
+		`sensor{name="d_pos_inf"} +Inf` + "\n" +
+		`sensor{name="e_float"} -Inf`
+	assert.Equal(t, expected, output)
+
+	// Round-trip the rendered values back through the text format
+	parsed := make(map[string]float64)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		value, err := strconv.ParseFloat(fields[1], 64)
+		require.NoError(t, err, "Line: %s", line)
+		parsed[fields[0]] = value
+	}
+	assert.True(t, math.IsNaN(parsed[`sensor{name="a_nan"}`]))
+	assert.True(t, math.IsInf(parsed[`sensor{name="b_inf"}`], 1))
+	assert.True(t, math.IsInf(parsed[`sensor{name="c_neg_inf"}`], -1))
+	assert.True(t, math.IsInf(parsed[`sensor{name="d_pos_inf"}`], 1))
+	assert.True(t, math.IsInf(parsed[`sensor{name="e_float"}`], -1))
+
+	// The structured output uses the exposition spelling, as JSON has no NaN
+	samples := tc.GetOutput("metricFamilies").([]interface{})[0].(map[string]interface{})["samples"].([]interface{})
+	assert.Equal(t, "NaN", samples[0].(map[string]interface{})["value"])
+	assert.Equal(t, "+Inf", samples[1].(map[string]interface{})["value"])
+}
+
+func TestActivity_Eval_NullSkipped(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "sensor",
+		nullHandling: nullSkip,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"temperature": 21.5,
+			"humidity":    nil,
+			"location":    nil,
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Equal(t, `sensor{name="temperature"} 21.5`, tc.GetOutput("prometheusMetric"))
+	assert.Equal(t, 2, tc.GetOutput("skippedFields"))
+}
+
+func TestActivity_Eval_NullStaleMarker(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "sensor",
+		nullHandling: nullStale,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"temperature": 21.5,
+			"humidity":    nil,
+			"room":        "lab",
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := `sensor{name="humidity",room="lab"} NaN` + "\n" +
+		`sensor{name="temperature",room="lab"} 21.5`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+
+	samples := tc.GetOutput("metricFamilies").([]interface{})[0].(map[string]interface{})["samples"].([]interface{})
+	assert.Equal(t, true, samples[0].(map[string]interface{})["stale"])
+	assert.Nil(t, samples[1].(map[string]interface{})["stale"])
+
+	result, err := act.convertToPrometheusFormat(map[string]interface{}{"humidity": nil})
+	require.NoError(t, err)
+	point := act.buildOTLP(result).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge().DataPoints[0]
+	assert.Equal(t, uint32(1), point.Flags)
+}
+
+func TestActivity_Eval_InfoMetric(t *testing.T) {
+	act := &Activity{
+		metricType:  "gauge",
+		metricName:  "build",
+		includeType: true,
+		infoMetrics: true,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"version":  "1.4.2",
+			"revision": "a1b2c3d",
+			"branch":   "main",
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := "# TYPE build_info gauge\n" +
+		`build_info{branch="main",revision="a1b2c3d",version="1.4.2"} 1`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+	assert.Equal(t, 1, tc.GetOutput("seriesCount"))
+}
+
+func TestActivity_Eval_InfoMetricMixedArray(t *testing.T) {
+	act := &Activity{
+		metricType:  "gauge",
+		metricName:  "device",
+		infoMetrics: true,
+	}
+
+	result, err := act.convertToPrometheusFormat(map[string]interface{}{
+		"metrics": []interface{}{
+			map[string]interface{}{"temperature": 21.5, "id": "d-1"},
+			map[string]interface{}{"id": "d-1", "model": "TX-100", "firmware": "v2.0"},
+			map[string]interface{}{},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Families, 2)
+	assert.Equal(t, "device", result.Families[0].Name)
+	assert.Equal(t, "device_info", result.Families[1].Name)
+	assert.Equal(t, "gauge", result.Families[1].Type)
+	assert.Equal(t, `device_info{firmware="v2.0",id="d-1",model="TX-100"} 1`, act.formatSample(result.Families[1].Samples[0]))
+	assert.Equal(t, 1, result.FailedObjects, "objects without labels are still rejected")
+}
+
+func TestActivity_Eval_InfoMetricDisabled(t *testing.T) {
+	act := &Activity{
+		metricType: "gauge",
+		metricName: "build",
+	}
+
+	_, err := act.convertToPrometheusFormat(map[string]interface{}{"version": "1.4.2"})
+	assert.Error(t, err)
+}
+
+func TestActivity_Eval_NamespacedReservedFields(t *testing.T) {
+	act := &Activity{
+		metricType:     "gauge",
+		metricName:     "disk",
+		includeHelp:    true,
+		timestamp:      true,
+		reservedFields: reservedNamespaced,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"_help":      "Disk usage",
+			"_timestamp": 1705314600000,
+			"_type":      "ignored",
+			"type":       "ssd",
+			"help":       "see runbook",
+			"used_bytes": 1024,
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := "# HELP disk Disk usage\n" +
+		`disk{name="used_bytes",help="see runbook",type="ssd"} 1024 1705314600000`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+}
+
+func TestActivity_Eval_ExtraReservedFields(t *testing.T) {
+	act := &Activity{
+		metricType:     "gauge",
+		metricName:     "disk",
+		reservedFields: reservedLegacy,
+		extraReserved:  []string{"request_id", "sequence"},
+	}
+
+	result, err := act.convertToPrometheusFormat(map[string]interface{}{
+		"type":       "ssd",
+		"request_id": "r-42",
+		"sequence":   7,
+		"used_bytes": 1024,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Families[0].Samples, 1)
+	assert.Equal(t, `disk{name="used_bytes"} 1024`, act.formatSample(result.Families[0].Samples[0]))
+}
+
+func TestNew_ReservedFields(t *testing.T) {
+	act, err := New(test.NewActivityInitContext(map[string]interface{}{
+		"extraReservedFields": " request_id, ,sequence ",
+	}, nil))
+	require.NoError(t, err)
+	assert.Equal(t, reservedLegacy, act.(*Activity).reservedFields)
+	assert.Equal(t, []string{"request_id", "sequence"}, act.(*Activity).extraReserved)
+
+	_, err = New(test.NewActivityInitContext(map[string]interface{}{"reservedFields": "none"}, nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package conformance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// goldenDir holds the fixtures and expected outputs of the activity module
+const goldenDir = "../testdata/golden"
+
+// TestOpenMetricsGolden parses the expected output of every openmetrics
+// fixture with the OpenMetrics parser of the Prometheus server. The
+// activity module's TestGolden keeps those files in sync with the encoder.
+func TestOpenMetricsGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join(goldenDir, "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	parsed := 0
+	for _, fixturePath := range fixtures {
+		data, err := os.ReadFile(fixturePath)
+		require.NoError(t, err)
+
+		var fixture struct {
+			Settings  map[string]interface{} `json:"settings"`
+			SkipParse string                 `json:"skipParse"`
+		}
+		require.NoError(t, json.Unmarshal(data, &fixture))
+		if fixture.Settings["outputFormat"] != "openmetrics" || fixture.SkipParse != "" {
+			continue
+		}
+
+		name := strings.TrimSuffix(filepath.Base(fixturePath), ".json")
+		t.Run(name, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join(goldenDir, name+".prom"))
+			require.NoError(t, err)
+			assert.NoError(t, parseOpenMetrics(string(output)), "output is not valid OpenMetrics")
+		})
+		parsed++
+	}
+	assert.NotZero(t, parsed, "no openmetrics fixtures found")
+}
+
+// parseOpenMetrics reads every entry of an OpenMetrics text. The parser
+// leaves the sample names and the placement of exemplars to the scraper, so
+// they are checked here: counter samples end in _total, histogram samples
+// are _bucket, _sum or _count samples, and only _total and _bucket samples
+// carry exemplars.
+func parseOpenMetrics(text string) error {
+	parser := textparse.NewOpenMetricsParser([]byte(text))
+	types := make(map[string]string)
+	for {
+		entry, err := parser.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch entry {
+		case textparse.EntryType:
+			name, metricType := parser.Type()
+			types[string(name)] = string(metricType)
+		case textparse.EntrySeries:
+			series, _, _ := parser.Series()
+			name := string(series)
+			if i := strings.IndexAny(name, "{ "); i >= 0 {
+				name = name[:i]
+			}
+			// A sample named like its counter or histogram family lacks
+			// the suffix
+			if metricType := types[name]; metricType == "counter" || metricType == "histogram" {
+				return fmt.Errorf("%s sample '%s' has no suffix", metricType, name)
+			}
+			var e exemplar.Exemplar
+			if parser.Exemplar(&e) && !strings.HasSuffix(name, "_total") && !strings.HasSuffix(name, "_bucket") {
+				return fmt.Errorf("sample '%s' cannot have an exemplar", name)
+			}
+		}
+	}
+}
+
+// TestParseOpenMetricsRejects checks that the suffix and exemplar checks
+// catch the mistakes they are meant for
+func TestParseOpenMetricsRejects(t *testing.T) {
+	for _, text := range []string{
+		"# TYPE jobs counter\njobs 1\n# EOF\n",
+		"# TYPE temp gauge\ntemp 1 # {trace_id=\"abc\"} 1\n# EOF\n",
+		"# TYPE jobs counter\njobs_total 1\n",
+	} {
+		assert.Error(t, parseOpenMetrics(text), text)
+	}
+}
@@ -0,0 +1,327 @@
+package prometheusmetrics
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	errMissingField   = errors.New("missing or non-numeric field")
+	errDivisionByZero = errors.New("division by zero")
+)
+
+// derivedMetric is a series computed from other fields of the same metric
+// object, e.g. memory_utilization = used / total * 100
+type derivedMetric struct {
+	Name string
+	Expr expression
+}
+
+// expression is a node of a parsed arithmetic expression
+type expression interface {
+	eval(fields map[string]interface{}) (float64, error)
+}
+
+type numberExpr float64
+
+type fieldExpr string
+
+type negateExpr struct {
+	operand expression
+}
+
+type binaryExpr struct {
+	op          byte
+	left, right expression
+}
+
+func (n numberExpr) eval(map[string]interface{}) (float64, error) {
+	return float64(n), nil
+}
+
+func (f fieldExpr) eval(fields map[string]interface{}) (float64, error) {
+	val, ok := fields[string(f)]
+	if !ok || val == nil {
+		return 0, fmt.Errorf("%w '%s'", errMissingField, string(f))
+	}
+	value, ok := numericValue(val)
+	if !ok {
+		return 0, fmt.Errorf("%w '%s'", errMissingField, string(f))
+	}
+	return value, nil
+}
+
+func (n negateExpr) eval(fields map[string]interface{}) (float64, error) {
+	value, err := n.operand.eval(fields)
+	return -value, err
+}
+
+func (b binaryExpr) eval(fields map[string]interface{}) (float64, error) {
+	left, err := b.left.eval(fields)
+	if err != nil {
+		return 0, err
+	}
+	right, err := b.right.eval(fields)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, errDivisionByZero
+		}
+		return left / right, nil
+	}
+}
+
+// parseDerivedMetrics parses derived metric definitions, one per line or
+// separated by semicolons, in the form name = expression. Expressions support
+// + - * /, unary minus, parentheses, numbers and field names. Field names that
+// are not identifiers, e.g. cpu.usage or bytes-in, are written in double
+// quotes or backticks.
+func parseDerivedMetrics(definitions string) ([]derivedMetric, error) {
+	var derived []derivedMetric
+	seen := make(map[string]bool)
+
+	for _, definition := range strings.FieldsFunc(definitions, func(r rune) bool { return r == '\n' || r == ';' }) {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+
+		name, exprText, found := strings.Cut(definition, "=")
+		name = strings.TrimSpace(name)
+		if !found || !isIdentifier(name) {
+			return nil, fmt.Errorf("invalid derived metric '%s': expected name = expression", definition)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("derived metric '%s' is defined more than once", name)
+		}
+		seen[name] = true
+
+		expr, err := parseExpression(exprText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for derived metric '%s': %w", name, err)
+		}
+		derived = append(derived, derivedMetric{Name: name, Expr: expr})
+	}
+
+	return derived, nil
+}
+
+// Kinds of expression tokens
+const (
+	tokenOperator = iota // + - * / ( )
+	tokenNumber
+	tokenField
+)
+
+// exprToken is a token of an expression. Text is the operator or the field
+// name without quotes; numbers are parsed by the tokenizer.
+type exprToken struct {
+	kind  int
+	text  string
+	value float64
+}
+
+// expressionParser is a recursive descent parser over a tokenized expression
+type expressionParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func parseExpression(text string) (expression, error) {
+	tokens, err := tokenizeExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// peek returns the operator at the current position, or "" for a number, a
+// field or the end of the expression
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+// parseSum parses term (('+' | '-') term)*
+func (p *expressionParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.tokens[p.pos].text[0]
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct parses unary (('*' | '/') unary)*
+func (p *expressionParser) parseProduct() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.tokens[p.pos].text[0]
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses '-' unary | primary
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.peek() == "-" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateExpr{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a number, a field name or a parenthesized expression
+func (p *expressionParser) parsePrimary() (expression, error) {
+	if p.pos == len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	token := p.tokens[p.pos]
+	switch {
+	case token.kind == tokenNumber:
+		p.pos++
+		return numberExpr(token.value), nil
+	case token.kind == tokenField:
+		p.pos++
+		return fieldExpr(token.text), nil
+	case token.text == "(":
+		p.pos++
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	default:
+		return nil, fmt.Errorf("unexpected '%s'", token.text)
+	}
+}
+
+// tokenizeExpression splits an expression into operators, parentheses,
+// numbers and field names
+func tokenizeExpression(text string) ([]exprToken, error) {
+	var tokens []exprToken
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case strings.ContainsRune("+-*/()", r):
+			tokens = append(tokens, exprToken{kind: tokenOperator, text: string(r)})
+			i += size
+		case unicode.IsDigit(r) || r == '.':
+			// Take the whole literal, including an exponent sign, so a
+			// malformed number such as 1.2.3 or 2x is reported as one
+			start := i
+			for i < len(text) {
+				c, size := utf8.DecodeRuneInString(text[i:])
+				if (c == '+' || c == '-') && (text[i-1] == 'e' || text[i-1] == 'E') {
+					i += size
+					continue
+				}
+				if c != '.' && c != '_' && !unicode.IsDigit(c) && !unicode.IsLetter(c) {
+					break
+				}
+				i += size
+			}
+			value, err := strconv.ParseFloat(text[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s'", text[start:i])
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: text[start:i], value: value})
+		case r == '"' || r == '`':
+			// Quoted field names use Go string syntax, so a double quoted
+			// name can contain escapes and a backticked one cannot
+			quoted, err := strconv.QuotedPrefix(text[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated field name at '%s'", text[i:])
+			}
+			name, _ := strconv.Unquote(quoted)
+			if name == "" {
+				return nil, errors.New("empty field name")
+			}
+			tokens = append(tokens, exprToken{kind: tokenField, text: name})
+			i += len(quoted)
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, exprToken{kind: tokenField, text: text[start:i]})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c'", r)
+		}
+	}
+
+	return tokens, nil
+}
+
+// isIdentifier reports whether s is a valid field or derived metric name
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
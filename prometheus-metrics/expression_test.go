@@ -0,0 +1,131 @@
+package prometheusmetrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDerivedMetrics(t *testing.T) {
+	derived, err := parseDerivedMetrics("memory_utilization = used / total * 100\nfree = total - used; ratio = -(a + b) / 2")
+	require.NoError(t, err)
+	require.Len(t, derived, 3)
+
+	fields := map[string]interface{}{"used": 512, "total": "2048", "a": 1, "b": 3}
+
+	tests := []struct {
+		name     string
+		expected float64
+	}{
+		{"memory_utilization", 25},
+		{"free", 1536},
+		{"ratio", -2},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.name, derived[i].Name)
+		value, err := derived[i].Expr.eval(fields)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, value, "Derived: %s", test.name)
+	}
+}
+
+func TestParseDerivedMetrics_QuotedFieldsAndNumbers(t *testing.T) {
+	derived, err := parseDerivedMetrics("bytes = \"net.bytes-in\" + `net.bytes-out`\nkb = `disk used` / 1e3\nsmall = 2.5E-1 * .5 + \"quote\\\"d\"")
+	require.NoError(t, err)
+	require.Len(t, derived, 3)
+
+	fields := map[string]interface{}{"net.bytes-in": 100, "net.bytes-out": 20, "disk used": 4096, `quote"d`: 1}
+
+	tests := []struct {
+		name     string
+		expected float64
+	}{
+		{"bytes", 120},
+		{"kb", 4.096},
+		{"small", 1.125},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.name, derived[i].Name)
+		value, err := derived[i].Expr.eval(fields)
+		assert.NoError(t, err)
+		assert.InDelta(t, test.expected, value, 1e-9, "Derived: %s", test.name)
+	}
+}
+
+func TestParseDerivedMetrics_Errors(t *testing.T) {
+	invalid := map[string]string{
+		"no_expression":   "expected name = expression",
+		"1bad = a + b":    "expected name = expression",
+		"x = a +":         "unexpected end of expression",
+		"x = (a + b":      "missing closing parenthesis",
+		"x = a $ b":       "unexpected character '$'",
+		"x = a b":         "unexpected 'b'",
+		"x = a\nx = b":    "defined more than once",
+		"x = 1.2.3 * a":   "invalid number '1.2.3'",
+		"x = 2é":          "invalid number '2é'",
+		"x = 2x":          "invalid number '2x'",
+		"x = 1e":          "invalid number '1e'",
+		"x = \"cpu.usage": "unterminated field name",
+		"x = `` + 1":      "empty field name",
+	}
+
+	for definition, message := range invalid {
+		_, err := parseDerivedMetrics(definition)
+		if assert.Error(t, err, "Definition: %q", definition) {
+			assert.Contains(t, err.Error(), message, "Definition: %q", definition)
+		}
+	}
+}
+
+func TestDerivedMetricEvalErrors(t *testing.T) {
+	derived, err := parseDerivedMetrics("utilization = used / total")
+	require.NoError(t, err)
+
+	_, err = derived[0].Expr.eval(map[string]interface{}{"used": 1, "total": 0})
+	assert.True(t, errors.Is(err, errDivisionByZero))
+
+	_, err = derived[0].Expr.eval(map[string]interface{}{"used": 1})
+	assert.True(t, errors.Is(err, errMissingField))
+
+	_, err = derived[0].Expr.eval(map[string]interface{}{"used": 1, "total": "n/a"})
+	assert.True(t, errors.Is(err, errMissingField))
+}
+
+func TestActivity_Eval_DerivedMetrics(t *testing.T) {
+	derived, err := parseDerivedMetrics("memory_utilization = used / total * 100")
+	require.NoError(t, err)
+
+	act := &Activity{
+		metricType:     "gauge",
+		metricName:     "memory",
+		derivedMetrics: derived,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"metrics": []interface{}{
+				map[string]interface{}{"used": 512, "total": 2048, "host": "a"},
+				map[string]interface{}{"used": 0, "total": 0, "host": "b"},
+				map[string]interface{}{"used": 10, "host": "c"},
+			},
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := `memory{name="total",host="a"} 2048` + "\n" +
+		`memory{name="used",host="a"} 512` + "\n" +
+		`memory{name="memory_utilization",host="a"} 25` + "\n" +
+		`memory{name="total",host="b"} 0` + "\n" +
+		`memory{name="used",host="b"} 0` + "\n" +
+		`memory{name="used",host="c"} 10`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+	// Division by zero and the missing field each skip one derived series
+	assert.Equal(t, 2, tc.GetOutput("skippedFields"))
+}
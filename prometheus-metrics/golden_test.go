@@ -0,0 +1,101 @@
+package prometheusmetrics
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenFixture is the content of a testdata/golden/<case>.json file
+type goldenFixture struct {
+	// Settings are passed to New as the activity settings
+	Settings map[string]interface{} `json:"settings"`
+	// Input is the metricData input of the activity
+	Input map[string]interface{} `json:"input"`
+	// SkipParse explains why the output cannot be checked with a parser,
+	// e.g. because it contains quoted names
+	SkipParse string `json:"skipParse"`
+}
+
+// TestGolden renders every fixture under testdata/golden and compares the
+// formatted output with the expected file next to it, <case>.prom for
+// Prometheus and OpenMetrics output. Prometheus output
+// is checked with the text format parser and OpenMetrics output with the
+// OpenMetrics parser of the Prometheus server. Run
+// `go test -run TestGolden -update` to regenerate the expected files.
+func TestGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "golden", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixturePath := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixturePath), ".json")
+
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(fixturePath)
+			require.NoError(t, err)
+
+			var fixture goldenFixture
+			require.NoError(t, json.Unmarshal(data, &fixture))
+			goldenPath := strings.TrimSuffix(fixturePath, ".json") + goldenExtension(fixture.Settings["outputFormat"])
+
+			act, err := New(test.NewActivityInitContext(fixture.Settings, nil))
+			require.NoError(t, err)
+
+			tc := test.NewActivityContext(act.Metadata())
+			tc.SetInputObject(&Input{MetricData: fixture.Input})
+			done, err := act.Eval(tc)
+			require.NoError(t, err)
+			assert.True(t, done)
+
+			output := tc.GetOutput("formattedMetrics").(string) + "\n"
+
+			if *update {
+				require.NoError(t, os.WriteFile(goldenPath, []byte(output), 0644))
+			}
+
+			expected, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), output)
+
+			if fixture.SkipParse != "" {
+				t.Logf("not parsed: %s", fixture.SkipParse)
+				return
+			}
+			switch fixture.Settings["outputFormat"] {
+			case nil, formatPrometheus:
+				var parser expfmt.TextParser
+				_, err = parser.TextToMetricFamilies(strings.NewReader(output))
+				assert.NoError(t, err, "output is not valid Prometheus text format")
+			case formatOpenMetrics:
+				// Parsed by the conformance module, which has the OpenMetrics
+				// parser of the Prometheus server as a dependency
+				assert.True(t, strings.HasSuffix(output, "\n# EOF\n"), "output does not end with # EOF")
+			}
+		})
+	}
+}
+
+// goldenExtension is the extension of the expected output file of a format
+func goldenExtension(format interface{}) string {
+	switch format {
+	case formatDogStatsD:
+		return ".statsd"
+	case formatInflux:
+		return ".lp"
+	case formatOTLP:
+		return ".otlp"
+	default:
+		return ".prom"
+	}
+}
@@ -0,0 +1,126 @@
+package prometheusmetrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// influxPrecisionDivisors converts millisecond timestamps to nanoseconds and
+// then divides by the precision's unit
+var influxPrecisionDivisors = map[string]int64{
+	"ns": 1,
+	"us": 1e3,
+	"ms": 1e6,
+	"s":  1e9,
+}
+
+// influxPoint is one line of line protocol: all fields of a metric object
+// that share the same measurement, tag set and timestamp
+type influxPoint struct {
+	measurement string
+	tags        []labelPair
+	fields      []labelPair
+	timestamp   int64
+}
+
+// renderInflux renders the metric model as InfluxDB line protocol, e.g.
+// system_metrics,host=server-01 cpu_usage=75.2,memory_usage=68.5 1705316200000000000.
+// The measurement is the metric name, string labels become tags and each
+// numeric field becomes a field of the point.
+func (a *Activity) renderInflux(result *conversionResult) []string {
+	var points []*influxPoint
+	index := make(map[string]*influxPoint)
+
+	for _, family := range result.Families {
+		for _, sample := range family.Samples {
+			// Line protocol has no representation for NaN or infinities
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+
+			var tags []labelPair
+			for _, label := range sample.Labels {
+				if label.Name == nameLabel {
+					continue
+				}
+				// Empty tag values are rejected by InfluxDB
+				if label.Value == "" {
+					continue
+				}
+				tags = append(tags, label)
+			}
+			sort.SliceStable(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+			key := a.influxSeriesKey(sample.Name, tags, sample.Timestamp)
+			point, ok := index[key]
+			if !ok {
+				point = &influxPoint{measurement: sample.Name, tags: tags, timestamp: sample.Timestamp}
+				index[key] = point
+				points = append(points, point)
+			}
+
+			field := sample.Field
+			if field == "" {
+				field = "value"
+			}
+			point.fields = append(point.fields, labelPair{Name: field, Value: formatValue(sample.Value)})
+		}
+	}
+
+	lines := make([]string, 0, len(points))
+	for _, point := range points {
+		var line strings.Builder
+		line.WriteString(escapeInfluxMeasurement(point.measurement))
+		for _, tag := range point.tags {
+			line.WriteString("," + escapeInfluxKey(tag.Name) + "=" + escapeInfluxKey(tag.Value))
+		}
+
+		fields := make([]string, 0, len(point.fields))
+		for _, field := range point.fields {
+			fields = append(fields, escapeInfluxKey(field.Name)+"="+field.Value)
+		}
+		line.WriteString(" " + strings.Join(fields, ","))
+
+		if a.timestamp {
+			line.WriteString(" " + strconv.FormatInt(a.influxTimestamp(point.timestamp), 10))
+		}
+		lines = append(lines, line.String())
+	}
+
+	return lines
+}
+
+// influxSeriesKey identifies the point a sample belongs to
+func (a *Activity) influxSeriesKey(measurement string, tags []labelPair, timestamp int64) string {
+	var key strings.Builder
+	key.WriteString(escapeInfluxMeasurement(measurement))
+	for _, tag := range tags {
+		key.WriteString("," + escapeInfluxKey(tag.Name) + "=" + escapeInfluxKey(tag.Value))
+	}
+	key.WriteString(" " + strconv.FormatInt(timestamp, 10))
+	return key.String()
+}
+
+// influxTimestamp converts a millisecond timestamp to the configured precision
+func (a *Activity) influxTimestamp(timestamp int64) int64 {
+	divisor, ok := influxPrecisionDivisors[a.influxPrecision]
+	if !ok {
+		divisor = influxPrecisionDivisors["ns"]
+	}
+	return timestamp * 1e6 / divisor
+}
+
+// escapeInfluxMeasurement escapes commas and spaces in a measurement name.
+// Line protocol has no escape for newlines and other control characters,
+// so they become escaped spaces.
+func escapeInfluxMeasurement(name string) string {
+	return replaceControlChars(strings.NewReplacer(",", `\,`, " ", `\ `).Replace(name), `\ `)
+}
+
+// escapeInfluxKey escapes commas, equals signs and spaces in tag keys, tag
+// values and field keys. Control characters become escaped spaces.
+func escapeInfluxKey(key string) string {
+	return replaceControlChars(strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(key), `\ `)
+}
@@ -0,0 +1,68 @@
+package prometheusmetrics
+
+import (
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivity_Eval_InfluxFormat(t *testing.T) {
+	act := &Activity{
+		metricType:      "gauge",
+		metricName:      "system_metrics",
+		timestamp:       true,
+		outputFormat:    formatInflux,
+		influxPrecision: "ms",
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"metrics": []interface{}{
+				map[string]interface{}{"cpu_usage": 75.2, "memory_usage": 68.5, "host": "server-01", "timestamp": int64(1705316200000)},
+				map[string]interface{}{"cpu_usage": 12, "host": "server-02", "timestamp": int64(1705316200000)},
+			},
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := "system_metrics,host=server-01 cpu_usage=75.2,memory_usage=68.5 1705316200000\n" +
+		"system_metrics,host=server-02 cpu_usage=12 1705316200000"
+	assert.Equal(t, expected, tc.GetOutput("formattedMetrics"))
+}
+
+func TestRenderInflux_Escaping(t *testing.T) {
+	act := &Activity{metricType: "gauge", metricName: "disk usage,total", nameEscaping: escapingAllowUTF8}
+
+	result, err := act.convertToPrometheusFormat(map[string]interface{}{
+		"used bytes": 10,
+		"a=b,c":      20,
+		"mount":      "/data, primary",
+		"device":     "sda=1",
+		"empty":      "",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`disk\ usage\,total,device=sda\=1,mount=/data\,\ primary a\=b\,c=20,used\ bytes=10`,
+	}, act.renderInflux(result))
+}
+
+func TestInfluxTimestampPrecision(t *testing.T) {
+	tests := map[string]int64{
+		"ns": 1705316200123000000,
+		"us": 1705316200123000,
+		"ms": 1705316200123,
+		"s":  1705316200,
+	}
+
+	for precision, expected := range tests {
+		act := &Activity{influxPrecision: precision}
+		assert.Equal(t, expected, act.influxTimestamp(1705316200123), "Precision: %s", precision)
+	}
+}
@@ -0,0 +1,147 @@
+package prometheusmetrics
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+// nameLabel is the label holding the source field of a sample. Source keys
+// that map to it are dropped, so a label with this name is always the field;
+// encoders that put the field into the measurement or metric name drop it
+// by name, since its value may be truncated or escaped.
+const nameLabel = "name"
+
+// labelPair is a single label name/value pair. Values are stored unescaped
+// and escaped by the encoder that renders them.
+type labelPair struct {
+	Name  string
+	Value string
+}
+
+// metricExemplar links a sample to an external reference such as a trace ID
+type metricExemplar struct {
+	Labels    []labelPair
+	Value     float64
+	Timestamp int64 // milliseconds since epoch
+}
+
+// metricSample is a single series value produced from a metric object
+type metricSample struct {
+	Name      string // series name
+	Field     string // metric object field the value was read from, normalized
+	Labels    []labelPair
+	Value     float64
+	Timestamp int64 // milliseconds since epoch
+	Exemplar  *metricExemplar
+	// StartTimestamp is the start of the interval a counter value covers,
+	// set by the temporality conversion; 0 when unknown
+	StartTimestamp int64
+}
+
+// metricFamily groups samples that share a name, type and HELP text
+type metricFamily struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []metricSample
+}
+
+// conversionResult is the internal model every output format is rendered from
+type conversionResult struct {
+	Families      []*metricFamily
+	SkippedFields int
+	// FailedObjects counts objects of a metrics array that were skipped
+	// because they could not be converted
+	FailedObjects int
+	// Collisions lists source keys that normalized to the same name
+	Collisions []labelCollision
+}
+
+// seriesCount returns the number of samples across all families
+func (r *conversionResult) seriesCount() int {
+	count := 0
+	for _, family := range r.Families {
+		count += len(family.Samples)
+	}
+	return count
+}
+
+// dropOversizedExemplars removes exemplars whose labels are longer than
+// OpenMetrics allows from every output and returns the number of samples
+// that lost one; the samples themselves are kept
+func (r *conversionResult) dropOversizedExemplars() int {
+	dropped := 0
+	for _, family := range r.Families {
+		for i := range family.Samples {
+			exemplar := family.Samples[i].Exemplar
+			if exemplar == nil {
+				continue
+			}
+			length := 0
+			for _, label := range exemplar.Labels {
+				length += utf8.RuneCountInString(label.Name) + utf8.RuneCountInString(label.Value)
+			}
+			if length > maxExemplarLabelRunes {
+				family.Samples[i].Exemplar = nil
+				dropped++
+			}
+		}
+	}
+	return dropped
+}
+
+// toStructured converts the model into plain maps and slices suitable for the
+// metricFamilies output, so downstream activities can inspect series without
+// re-parsing the exposition text
+func (r *conversionResult) toStructured() []interface{} {
+	families := make([]interface{}, 0, len(r.Families))
+	for _, family := range r.Families {
+		samples := make([]interface{}, 0, len(family.Samples))
+		for _, sample := range family.Samples {
+			s := map[string]interface{}{
+				"name":      sample.Name,
+				"field":     sample.Field,
+				"labels":    labelsToMap(sample.Labels),
+				"value":     structuredValue(sample.Value),
+				"timestamp": sample.Timestamp,
+			}
+			if isStaleNaN(sample.Value) {
+				s["stale"] = true
+			}
+			if sample.Exemplar != nil {
+				s["exemplar"] = map[string]interface{}{
+					"labels":    labelsToMap(sample.Exemplar.Labels),
+					"value":     structuredValue(sample.Exemplar.Value),
+					"timestamp": sample.Exemplar.Timestamp,
+				}
+			}
+			samples = append(samples, s)
+		}
+
+		families = append(families, map[string]interface{}{
+			"name":    family.Name,
+			"type":    family.Type,
+			"help":    family.Help,
+			"samples": samples,
+		})
+	}
+	return families
+}
+
+// structuredValue returns finite values as numbers and NaN or infinities as
+// their exposition text ("NaN", "+Inf", "-Inf"), which JSON cannot represent
+func structuredValue(value float64) interface{} {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return formatValue(value)
+	}
+	return value
+}
+
+// labelsToMap converts label pairs into a name to value map
+func labelsToMap(labels []labelPair) map[string]interface{} {
+	m := make(map[string]interface{}, len(labels))
+	for _, label := range labels {
+		m[label.Name] = label.Value
+	}
+	return m
+}
@@ -0,0 +1,107 @@
+package prometheusmetrics
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported values of the nameEscaping setting. They follow the escaping
+// schemes Prometheus 3 negotiates with scrapers.
+const (
+	escapingUnderscores = "underscores"
+	escapingDots        = "dots"
+	escapingValues      = "values"
+	escapingAllowUTF8   = "allow-utf-8"
+)
+
+// sanitizeMetricName applies the configured escaping scheme to a metric name
+func (a *Activity) sanitizeMetricName(name string) string {
+	return escapeName(name, a.nameEscaping, true)
+}
+
+// isValidLegacyRune reports whether r may appear at position i of a classic
+// metric name ([a-zA-Z_:][a-zA-Z0-9_:]*) or label name ([a-zA-Z_][a-zA-Z0-9_]*)
+func isValidLegacyRune(r rune, i int, allowColon bool) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' ||
+		(allowColon && r == ':') || (r >= '0' && r <= '9' && i > 0)
+}
+
+// isValidLegacyName reports whether name is a valid classic metric name (when
+// allowColon is set) or label name
+func isValidLegacyName(name string, allowColon bool) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !isValidLegacyRune(r, i, allowColon) {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeName converts a metric or label name according to an escaping
+// scheme:
+//   - underscores replaces every invalid character with '_' (the default)
+//   - dots writes '_' as "__", '.' as "_dot_" and other invalid characters as "__"
+//   - values prefixes the name with "U__" and writes invalid characters as
+//     _<hex code point>_, keeping already valid names unchanged
+//   - allow-utf-8 keeps the name as is; it is quoted when rendered
+func escapeName(name, scheme string, allowColon bool) string {
+	var escaped strings.Builder
+
+	switch scheme {
+	case escapingAllowUTF8:
+		return name
+	case escapingDots:
+		for i, r := range name {
+			switch {
+			case r == '_':
+				escaped.WriteString("__")
+			case r == '.':
+				escaped.WriteString("_dot_")
+			case isValidLegacyRune(r, i, allowColon):
+				escaped.WriteRune(r)
+			default:
+				escaped.WriteString("__")
+			}
+		}
+	case escapingValues:
+		if isValidLegacyName(name, allowColon) {
+			return name
+		}
+		escaped.WriteString("U__")
+		for i, r := range name {
+			switch {
+			case r == '_':
+				escaped.WriteString("__")
+			case isValidLegacyRune(r, i, allowColon):
+				escaped.WriteRune(r)
+			case r == utf8.RuneError:
+				escaped.WriteString("_FFFD_")
+			default:
+				escaped.WriteString("_" + strconv.FormatInt(int64(r), 16) + "_")
+			}
+		}
+	default:
+		for i, r := range name {
+			if isValidLegacyRune(r, i, allowColon) {
+				escaped.WriteRune(r)
+			} else {
+				escaped.WriteRune('_')
+			}
+		}
+	}
+
+	return escaped.String()
+}
+
+// formatName renders a metric or label name for the exposition format,
+// quoting names that are only valid in UTF-8 mode
+func (a *Activity) formatName(name string, allowColon bool) string {
+	if isValidLegacyName(name, allowColon) {
+		return name
+	}
+	return `"` + a.sanitizeLabelValue(name) + `"`
+}
@@ -0,0 +1,100 @@
+package prometheusmetrics
+
+import (
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeName(t *testing.T) {
+	tests := []struct {
+		scheme   string
+		input    string
+		expected string
+	}{
+		{escapingUnderscores, "device.name", "device_name"},
+		{escapingUnderscores, "température", "temp_rature"},
+		{escapingUnderscores, "valid_name", "valid_name"},
+		{escapingDots, "device.name", "device_dot_name"},
+		{escapingDots, "http_requests", "http__requests"},
+		{escapingDots, "température", "temp__rature"},
+		{escapingValues, "valid_name", "valid_name"},
+		{escapingValues, "device.name", "U__device_2e_name"},
+		{escapingValues, "température", "U__temp_e9_rature"},
+		{escapingAllowUTF8, "température", "température"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, escapeName(test.input, test.scheme, false), "Scheme: %s, input: %s", test.scheme, test.input)
+	}
+
+	// Colons are only valid in metric names
+	assert.Equal(t, "job:requests:rate5m", escapeName("job:requests:rate5m", escapingUnderscores, true))
+	assert.Equal(t, "job_requests", escapeName("job:requests", escapingUnderscores, false))
+}
+
+func TestActivity_Eval_UTF8Names(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "device.metrics",
+		includeHelp:  true,
+		includeType:  true,
+		nameEscaping: escapingAllowUTF8,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"température": 21.5,
+			"device.name": "capteur-é",
+			"Gerät":       "pompe",
+			"Geraet":      "ventil",
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	expected := `# HELP "device.metrics" Generated metric from JSON data` + "\n" +
+		`# TYPE "device.metrics" gauge` + "\n" +
+		`{"device.metrics",name="température",Geraet="ventil","Gerät"="pompe","device.name"="capteur-é"} 21.5`
+	assert.Equal(t, expected, tc.GetOutput("prometheusMetric"))
+}
+
+func TestActivity_Eval_UTF8NamesValidMetricName(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "device_metrics",
+		nameEscaping: escapingAllowUTF8,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{"value": 1, "device.name": "pump"},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Equal(t, `device_metrics{name="value","device.name"="pump"} 1`, tc.GetOutput("prometheusMetric"))
+}
+
+func TestActivity_Eval_DotsEscaping(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "device.metrics",
+		nameEscaping: escapingDots,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{"value": 1, "device.name": "pump"},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Equal(t, `device_dot_metrics{name="value",device_dot_name="pump"} 1`, tc.GetOutput("prometheusMetric"))
+}
@@ -0,0 +1,73 @@
+package prometheusmetrics
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Supported values of the labelNormalization setting
+const (
+	normalizeNone         = "none"
+	normalizeLowercase    = "lowercase"
+	normalizeCamelToSnake = "camel_to_snake"
+	normalizeSnakeCase    = "snake_case"
+)
+
+// labelCollision records source keys that normalized to the same name
+type labelCollision struct {
+	Kind string // "label" or "metric"
+	Name string
+	Keys []string // source keys in input order; the first one is kept
+}
+
+// normalizeKey applies the configured normalization strategy to a source key
+func (a *Activity) normalizeKey(key string) string {
+	switch a.labelNormalization {
+	case normalizeLowercase:
+		return strings.ToLower(key)
+	case normalizeCamelToSnake:
+		return camelToSnake(key)
+	case normalizeSnakeCase:
+		return snakeCase(key)
+	default:
+		return key
+	}
+}
+
+// normalizeLabelName turns a source key into a label name: normalization,
+// then the label prefix, then the escaping scheme
+func (a *Activity) normalizeLabelName(key string) string {
+	return a.sanitizeLabelName(a.labelPrefix + a.normalizeKey(key))
+}
+
+// camelToSnake splits camelCase and PascalCase words with underscores and
+// lowercases the result, keeping acronyms together: deviceID -> device_id,
+// HTTPServer -> http_server
+func camelToSnake(s string) string {
+	runes := []rune(s)
+	var out strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				out.WriteRune('_')
+			}
+		}
+		out.WriteRune(unicode.ToLower(r))
+	}
+	return out.String()
+}
+
+// snakeCase converts camel case like camelToSnake and also folds every run of
+// separators (spaces, dashes, dots, ...) into a single underscore, so
+// deviceID, device-id and "Device Id" all become device_id
+func snakeCase(s string) string {
+	words := strings.FieldsFunc(camelToSnake(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return s
+	}
+	return strings.Join(words, "_")
+}
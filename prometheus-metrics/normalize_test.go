@@ -0,0 +1,95 @@
+package prometheusmetrics
+
+import (
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	cases := []struct {
+		strategy string
+		in       string
+		want     string
+	}{
+		{normalizeNone, "deviceID", "deviceID"},
+		{normalizeLowercase, "Device-ID", "device-id"},
+		{normalizeCamelToSnake, "deviceID", "device_id"},
+		{normalizeCamelToSnake, "HTTPServerName", "http_server_name"},
+		{normalizeCamelToSnake, "cpu2Usage", "cpu2_usage"},
+		{normalizeCamelToSnake, "device-id", "device-id"},
+		{normalizeSnakeCase, "deviceID", "device_id"},
+		{normalizeSnakeCase, "device-id", "device_id"},
+		{normalizeSnakeCase, "Device Id", "device_id"},
+		{normalizeSnakeCase, "  rack.unit--Number ", "rack_unit_number"},
+		{normalizeSnakeCase, "---", "---"},
+	}
+
+	for _, c := range cases {
+		act := &Activity{labelNormalization: c.strategy}
+		assert.Equal(t, c.want, act.normalizeKey(c.in), "%s(%q)", c.strategy, c.in)
+	}
+}
+
+func TestActivity_Eval_LabelNormalization(t *testing.T) {
+	act := &Activity{
+		metricType:         "gauge",
+		metricName:         "device_metrics",
+		labelNormalization: normalizeSnakeCase,
+		labelPrefix:        "src_",
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"cpuTemp":   61,
+			"deviceID":  "d-1",
+			"Site Name": "berlin",
+		},
+	})
+	done, err := act.Eval(tc)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, `device_metrics{name="cpu_temp",src_site_name="berlin",src_device_id="d-1"} 61`, tc.GetOutput("prometheusMetric"))
+}
+
+func TestActivity_Eval_NormalizationCollisions(t *testing.T) {
+	act := &Activity{
+		metricType:         "gauge",
+		metricName:         "device_metrics",
+		labelNormalization: normalizeSnakeCase,
+	}
+
+	result, err := act.convertToPrometheusFormat(map[string]interface{}{
+		"deviceID":  "a",
+		"device-id": "b",
+		"Device Id": "c",
+		"Name":      "sensor",
+		"cpuTemp":   61,
+		"cpu_temp":  62,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, result.Families[0].Samples, 1)
+	sample := result.Families[0].Samples[0]
+	assert.Equal(t, 61.0, sample.Value)
+	assert.Equal(t, []labelPair{{Name: "name", Value: "cpu_temp"}, {Name: "device_id", Value: "c"}}, sample.Labels)
+
+	assert.Equal(t, []labelCollision{
+		{Kind: "label", Name: "name", Keys: []string{"Name"}},
+		{Kind: "label", Name: "device_id", Keys: []string{"Device Id", "device-id", "deviceID"}},
+		{Kind: "metric", Name: "cpu_temp", Keys: []string{"cpuTemp", "cpu_temp"}},
+	}, result.Collisions)
+	assert.Equal(t, 4, result.SkippedFields)
+}
+
+func TestNew_LabelNormalization(t *testing.T) {
+	_, err := New(test.NewActivityInitContext(map[string]interface{}{"labelNormalization": "kebab"}, nil))
+	assert.Error(t, err)
+
+	act, err := New(test.NewActivityInitContext(map[string]interface{}{}, nil))
+	require.NoError(t, err)
+	assert.Equal(t, normalizeNone, act.(*Activity).labelNormalization)
+}
@@ -0,0 +1,280 @@
+package prometheusmetrics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Supported values of the otlpProtocol setting
+const (
+	otlpProtocolProtobuf = "http/protobuf"
+	otlpProtocolJSON     = "http/json"
+)
+
+// otlpScopeName identifies this activity as the instrumentation scope
+const otlpScopeName = "github.com/kulbhushanbhalerao/flogo-extensions/prometheus-metrics"
+
+// otlpPushTimeout bounds a single export request to the collector
+const otlpPushTimeout = 10 * time.Second
+
+// buildOTLP converts the metric model into an OTLP MetricsData message.
+// Gauges map to Gauge, counters to a monotonic Sum (delta when the
+// temporality setting is cumulativeToDelta, cumulative otherwise), histograms
+// and summaries to a single-observation Histogram or Summary data point.
+// MetricsData is wire compatible with ExportMetricsServiceRequest.
+func (a *Activity) buildOTLP(result *conversionResult) *metricspb.MetricsData {
+	var startTime uint64
+	if !a.startTime.IsZero() {
+		startTime = uint64(a.startTime.UnixNano())
+	}
+
+	metrics := make([]*metricspb.Metric, 0, len(result.Families))
+	for _, family := range result.Families {
+		metric := &metricspb.Metric{
+			Name:        family.Name,
+			Description: family.Help,
+		}
+
+		switch family.Type {
+		case "counter":
+			sum := &metricspb.Sum{
+				IsMonotonic:            true,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			if a.temporality == temporalityCumulativeToDelta {
+				sum.AggregationTemporality = metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+			}
+			for _, sample := range family.Samples {
+				sampleStart := startTime
+				if sample.StartTimestamp != 0 {
+					sampleStart = uint64(sample.StartTimestamp) * uint64(time.Millisecond)
+				}
+				sum.DataPoints = append(sum.DataPoints, a.otlpNumberDataPoint(sample, sampleStart))
+			}
+			metric.Data = &metricspb.Metric_Sum{Sum: sum}
+		case "histogram":
+			histogram := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, sample := range family.Samples {
+				value := sample.Value
+				histogram.DataPoints = append(histogram.DataPoints, &metricspb.HistogramDataPoint{
+					Attributes:        otlpAttributes(sample.Labels),
+					StartTimeUnixNano: startTime,
+					TimeUnixNano:      uint64(sample.Timestamp) * uint64(time.Millisecond),
+					Count:             1,
+					Sum:               &value,
+					BucketCounts:      []uint64{1},
+					Exemplars:         a.otlpExemplars(sample),
+					Flags:             otlpFlags(sample.Value),
+				})
+			}
+			metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		case "summary":
+			summary := &metricspb.Summary{}
+			for _, sample := range family.Samples {
+				summary.DataPoints = append(summary.DataPoints, &metricspb.SummaryDataPoint{
+					Attributes:        otlpAttributes(sample.Labels),
+					StartTimeUnixNano: startTime,
+					TimeUnixNano:      uint64(sample.Timestamp) * uint64(time.Millisecond),
+					Count:             1,
+					Sum:               sample.Value,
+					Flags:             otlpFlags(sample.Value),
+				})
+			}
+			metric.Data = &metricspb.Metric_Summary{Summary: summary}
+		default:
+			gauge := &metricspb.Gauge{}
+			for _, sample := range family.Samples {
+				gauge.DataPoints = append(gauge.DataPoints, a.otlpNumberDataPoint(sample, 0))
+			}
+			metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return &metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: otlpAttributes(a.resourceAttributes)},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otlpScopeName, Version: "1.0.0"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// otlpNumberDataPoint converts a sample into a gauge or sum data point
+func (a *Activity) otlpNumberDataPoint(sample metricSample, startTime uint64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        otlpAttributes(sample.Labels),
+		StartTimeUnixNano: startTime,
+		TimeUnixNano:      uint64(sample.Timestamp) * uint64(time.Millisecond),
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: sample.Value},
+		Exemplars:         a.otlpExemplars(sample),
+		Flags:             otlpFlags(sample.Value),
+	}
+}
+
+// otlpFlags marks stale samples as having no recorded value
+func otlpFlags(value float64) uint32 {
+	if isStaleNaN(value) {
+		return uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+	}
+	return 0
+}
+
+// otlpExemplars converts the sample's exemplar. A label holding a 32 digit
+// hex trace ID is mapped to the exemplar's trace_id, other labels are kept
+// as filtered attributes.
+func (a *Activity) otlpExemplars(sample metricSample) []*metricspb.Exemplar {
+	if sample.Exemplar == nil {
+		return nil
+	}
+
+	exemplar := &metricspb.Exemplar{
+		TimeUnixNano: uint64(sample.Exemplar.Timestamp) * uint64(time.Millisecond),
+		Value:        &metricspb.Exemplar_AsDouble{AsDouble: sample.Exemplar.Value},
+	}
+	for _, label := range sample.Exemplar.Labels {
+		if traceID, err := hex.DecodeString(label.Value); err == nil && len(traceID) == 16 && exemplar.TraceId == nil {
+			exemplar.TraceId = traceID
+			continue
+		}
+		exemplar.FilteredAttributes = append(exemplar.FilteredAttributes, otlpAttributes([]labelPair{label})...)
+	}
+
+	return []*metricspb.Exemplar{exemplar}
+}
+
+// otlpAttributes converts label pairs into OTLP string attributes
+func otlpAttributes(labels []labelPair) []*commonpb.KeyValue {
+	if len(labels) == 0 {
+		return nil
+	}
+	attributes := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, label := range labels {
+		attributes = append(attributes, &commonpb.KeyValue{
+			Key:   label.Name,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: label.Value}},
+		})
+	}
+	return attributes
+}
+
+// encodeOTLP serializes the metrics in the wire format of the given protocol
+func encodeOTLP(data *metricspb.MetricsData, protocol string) ([]byte, error) {
+	if protocol == otlpProtocolJSON {
+		return marshalOTLPJSON(data)
+	}
+	return proto.Marshal(data)
+}
+
+// marshalOTLPJSON renders OTLP/JSON. It differs from the protojson defaults:
+// enums are written as integers and trace and span IDs as hex strings
+// instead of base64. The result is re-encoded for stable output.
+func marshalOTLPJSON(data *metricspb.MetricsData) ([]byte, error) {
+	raw, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	hexEncodeIDs(doc)
+
+	return json.Marshal(doc)
+}
+
+// hexEncodeIDs rewrites base64 traceId and spanId values as hex
+func hexEncodeIDs(node interface{}) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if key == "traceId" || key == "spanId" {
+				if encoded, ok := child.(string); ok {
+					if id, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+						v[key] = hex.EncodeToString(id)
+					}
+				}
+				continue
+			}
+			hexEncodeIDs(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			hexEncodeIDs(child)
+		}
+	}
+}
+
+// pushOTLP exports the metrics to an OTLP/HTTP collector endpoint
+func pushOTLP(endpoint, protocol string, headers []labelPair, data *metricspb.MetricsData) error {
+	body, err := encodeOTLP(data, protocol)
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP metrics: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	if protocol == otlpProtocolJSON {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+	for _, header := range headers {
+		req.Header.Set(header.Name, header.Value)
+	}
+
+	client := &http.Client{Timeout: otlpPushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to OTLP endpoint '%s': %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("OTLP endpoint '%s' returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// parseKeyValueList parses a comma separated key=value list, the format of
+// OTEL_RESOURCE_ATTRIBUTES and OTEL_EXPORTER_OTLP_HEADERS
+func parseKeyValueList(list string) ([]labelPair, error) {
+	var pairs []labelPair
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, found := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid key=value pair '%s'", item)
+		}
+		pairs = append(pairs, labelPair{Name: key, Value: strings.TrimSpace(value)})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,162 @@
+package prometheusmetrics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestActivity_Eval_OTLPFormat(t *testing.T) {
+	act := &Activity{
+		metricType:         "counter",
+		metricName:         "http_requests_total",
+		outputFormat:       formatOTLP,
+		exemplarField:      "traceId",
+		exemplarLabelName:  "trace_id",
+		resourceAttributes: []labelPair{{Name: "service.name", Value: "orders"}},
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"requests":  42,
+			"method":    "GET",
+			"traceId":   "4bf92f3577b34da6a3ce929d0e0e4736",
+			"timestamp": int64(1705316200000),
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(tc.GetOutput("formattedMetrics").(string)), &doc))
+
+	resourceMetrics := doc["resourceMetrics"].([]interface{})[0].(map[string]interface{})
+	resource := resourceMetrics["resource"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "orders"}},
+	}, resource["attributes"])
+
+	metric := resourceMetrics["scopeMetrics"].([]interface{})[0].(map[string]interface{})["metrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "http_requests_total", metric["name"])
+
+	sum := metric["sum"].(map[string]interface{})
+	assert.Equal(t, true, sum["isMonotonic"])
+	assert.Equal(t, float64(2), sum["aggregationTemporality"])
+
+	point := sum["dataPoints"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(42), point["asDouble"])
+	assert.Equal(t, "1705316200000000000", point["timeUnixNano"])
+
+	exemplar := point["exemplars"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", exemplar["traceId"])
+}
+
+func TestBuildOTLP_MetricTypes(t *testing.T) {
+	data := map[string]interface{}{"latency": 0.25, "timestamp": int64(1705316200000)}
+
+	act := &Activity{metricType: "gauge", metricName: "latency"}
+	result, err := act.convertToPrometheusFormat(data)
+	require.NoError(t, err)
+	gauge := act.buildOTLP(result).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge()
+	require.NotNil(t, gauge)
+	assert.Equal(t, 0.25, gauge.DataPoints[0].GetAsDouble())
+
+	act = &Activity{metricType: "histogram", metricName: "latency"}
+	result, err = act.convertToPrometheusFormat(data)
+	require.NoError(t, err)
+	histogram := act.buildOTLP(result).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetHistogram()
+	require.NotNil(t, histogram)
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, histogram.AggregationTemporality)
+	assert.Equal(t, uint64(1), histogram.DataPoints[0].Count)
+	assert.Equal(t, 0.25, histogram.DataPoints[0].GetSum())
+}
+
+func TestActivity_Eval_PushOTLP(t *testing.T) {
+	var received *metricspb.MetricsData
+	var contentType, apiKey string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		apiKey = r.Header.Get("X-Api-Key")
+		body, _ := io.ReadAll(r.Body)
+		received = &metricspb.MetricsData{}
+		if err := proto.Unmarshal(body, received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "cpu_usage",
+		otlpEndpoint: server.URL + "/v1/metrics",
+		otlpProtocol: otlpProtocolProtobuf,
+		otlpHeaders:  []labelPair{{Name: "X-Api-Key", Value: "secret"}},
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{"cpu": 75.2, "host": "server-01"},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "application/x-protobuf", contentType)
+	assert.Equal(t, "secret", apiKey)
+	require.NotNil(t, received)
+
+	point := received.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetGauge().DataPoints[0]
+	assert.Equal(t, 75.2, point.GetAsDouble())
+	assert.Equal(t, "host", point.Attributes[1].Key)
+	assert.Equal(t, "server-01", point.Attributes[1].Value.GetStringValue())
+}
+
+func TestActivity_Eval_PushOTLPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "cpu_usage",
+		otlpEndpoint: server.URL,
+		otlpProtocol: otlpProtocolJSON,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{MetricData: map[string]interface{}{"cpu": 1}})
+
+	done, err := act.Eval(tc)
+	assert.False(t, done)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "429")
+	assert.Contains(t, err.Error(), "quota exceeded")
+}
+
+func TestParseKeyValueList(t *testing.T) {
+	pairs, err := parseKeyValueList("service.name=orders, deployment.environment = prod,")
+	require.NoError(t, err)
+	assert.Equal(t, []labelPair{
+		{Name: "service.name", Value: "orders"},
+		{Name: "deployment.environment", Value: "prod"},
+	}, pairs)
+
+	_, err = parseKeyValueList("missing-separator")
+	assert.Error(t, err)
+}
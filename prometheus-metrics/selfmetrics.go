@@ -0,0 +1,84 @@
+package prometheusmetrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultSelfMetricsNamespace prefixes the activity's own metrics
+const defaultSelfMetricsNamespace = "flogo_prometheus_activity"
+
+// selfStats holds the activity's own counters. They are cumulative over the
+// lifetime of the activity instance and safe for concurrent Evals.
+type selfStats struct {
+	evals            atomic.Uint64
+	seriesEmitted    atomic.Uint64
+	fieldsSkipped    atomic.Uint64
+	conversionErrors atomic.Uint64
+	durationNanos    atomic.Uint64
+	conversions      atomic.Uint64
+}
+
+// recordConversion updates the counters after a conversion attempt. result
+// is nil when the conversion failed.
+func (s *selfStats) recordConversion(result *conversionResult, duration time.Duration) {
+	s.conversions.Add(1)
+	s.durationNanos.Add(uint64(duration.Nanoseconds()))
+
+	if result == nil {
+		s.conversionErrors.Add(1)
+		return
+	}
+	s.seriesEmitted.Add(uint64(result.seriesCount()))
+	s.fieldsSkipped.Add(uint64(result.SkippedFields))
+	s.conversionErrors.Add(uint64(result.FailedObjects))
+}
+
+// selfMetricFamilies renders the current counters as metric families named
+// <namespace>_evals_total, <namespace>_series_emitted_total and so on
+func (a *Activity) selfMetricFamilies(timestamp int64) []*metricFamily {
+	namespace := a.selfMetricsNamespace
+	if namespace == "" {
+		namespace = defaultSelfMetricsNamespace
+	}
+
+	counter := func(name, help string, value uint64) *metricFamily {
+		return &metricFamily{
+			Name: namespace + "_" + name,
+			Help: help,
+			Type: "counter",
+			Samples: []metricSample{{
+				Name:      namespace + "_" + name,
+				Value:     float64(value),
+				Timestamp: timestamp,
+			}},
+		}
+	}
+
+	durationName := namespace + "_conversion_duration_seconds"
+	duration := &metricFamily{
+		Name: durationName,
+		Help: "Time spent converting metric data.",
+		Type: "summary",
+		Samples: []metricSample{
+			{
+				Name:      durationName + "_sum",
+				Value:     time.Duration(a.stats.durationNanos.Load()).Seconds(),
+				Timestamp: timestamp,
+			},
+			{
+				Name:      durationName + "_count",
+				Value:     float64(a.stats.conversions.Load()),
+				Timestamp: timestamp,
+			},
+		},
+	}
+
+	return []*metricFamily{
+		counter("evals_total", "Number of times the activity was evaluated.", a.stats.evals.Load()),
+		counter("series_emitted_total", "Number of series emitted.", a.stats.seriesEmitted.Load()),
+		counter("fields_skipped_total", "Number of input fields that were neither a metric nor a label.", a.stats.fieldsSkipped.Load()),
+		counter("conversion_errors_total", "Number of metric objects that could not be converted.", a.stats.conversionErrors.Load()),
+		duration,
+	}
+}
@@ -0,0 +1,108 @@
+package prometheusmetrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivity_Eval_SelfMetricsCountsEmptyInput(t *testing.T) {
+	act := &Activity{metricType: "gauge", metricName: "sensor", selfMetrics: true}
+
+	tc := test.NewActivityContext(act.Metadata())
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	tc.SetInputObject(&Input{MetricData: map[string]interface{}{"temperature": 21.5}})
+	_, err = act.Eval(tc)
+	assert.NoError(t, err)
+
+	assert.Contains(t, tc.GetOutput("prometheusMetric"), "flogo_prometheus_activity_evals_total 2\n")
+}
+
+func TestActivity_Eval_SelfMetrics(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "sensor",
+		includeType:  true,
+		nullHandling: nullSkip,
+		selfMetrics:  true,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"temperature": 21.5,
+			"humidity":    40,
+			"location":    nil,
+		},
+	})
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	// A failing conversion counts as an error
+	tc.SetInputObject(&Input{MetricData: map[string]interface{}{"room": "lab"}})
+	_, err = act.Eval(tc)
+	assert.Error(t, err)
+
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"metrics": []interface{}{
+				map[string]interface{}{"temperature": 20},
+				map[string]interface{}{"room": "lab"},
+			},
+		},
+	})
+	done, err = act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	output := tc.GetOutput("prometheusMetric").(string)
+	assert.True(t, strings.HasPrefix(output, "# TYPE sensor gauge\nsensor{name=\"temperature\"} 20\n"))
+	assert.Contains(t, output, "# TYPE flogo_prometheus_activity_evals_total counter\nflogo_prometheus_activity_evals_total 3\n")
+	assert.Contains(t, output, "flogo_prometheus_activity_series_emitted_total 3\n")
+	assert.Contains(t, output, "flogo_prometheus_activity_fields_skipped_total 1\n")
+	assert.Contains(t, output, "flogo_prometheus_activity_conversion_errors_total 2\n")
+	assert.Contains(t, output, "# TYPE flogo_prometheus_activity_conversion_duration_seconds summary\n")
+	assert.Contains(t, output, "flogo_prometheus_activity_conversion_duration_seconds_count 3")
+
+	// Self metrics are not part of the converted series
+	assert.Equal(t, 1, tc.GetOutput("seriesCount"))
+	assert.Len(t, tc.GetOutput("metricFamilies"), 1)
+}
+
+func TestActivity_Eval_SelfMetricsDisabled(t *testing.T) {
+	act := &Activity{
+		metricType: "gauge",
+		metricName: "sensor",
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{MetricData: map[string]interface{}{"temperature": 21.5}})
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Equal(t, `sensor{name="temperature"} 21.5`, tc.GetOutput("prometheusMetric"))
+	assert.Equal(t, uint64(1), act.stats.evals.Load())
+}
+
+func TestSelfMetricFamilies_Namespace(t *testing.T) {
+	act := &Activity{selfMetricsNamespace: "edge_agent"}
+	families := act.selfMetricFamilies(0)
+
+	var names []string
+	for _, family := range families {
+		names = append(names, family.Name)
+	}
+	assert.Equal(t, []string{
+		"edge_agent_evals_total",
+		"edge_agent_series_emitted_total",
+		"edge_agent_fields_skipped_total",
+		"edge_agent_conversion_errors_total",
+		"edge_agent_conversion_duration_seconds",
+	}, names)
+}
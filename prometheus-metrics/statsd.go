@@ -0,0 +1,129 @@
+package prometheusmetrics
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxStatsdPacketSize keeps datagrams below the typical Ethernet MTU so
+// they are not fragmented on the way to the agent
+const maxStatsdPacketSize = 1432
+
+// statsdType maps the configured Prometheus metric type to the StatsD type
+// suffix. Summaries are sent as timers, which the agent aggregates into
+// percentiles. The agent adds up |c values as increments, so counters are
+// only sent as |c when temporality is cumulativeToDelta; a cumulative count
+// is sent as a gauge, otherwise every push would add the running total
+// again.
+func (a *Activity) statsdType(metricType string) string {
+	switch metricType {
+	case "counter":
+		if a.temporality != temporalityCumulativeToDelta {
+			return "g"
+		}
+		return "c"
+	case "histogram":
+		return "h"
+	case "summary":
+		return "ms"
+	default:
+		return "g"
+	}
+}
+
+// renderStatsd renders the metric model as DogStatsD lines, e.g.
+// flogo_metric.temp:21.5|g|#host:server-01. The source field becomes part of
+// the metric name and the remaining labels become tags.
+func (a *Activity) renderStatsd(result *conversionResult) []string {
+	var lines []string
+
+	for _, family := range result.Families {
+		suffix := a.statsdType(family.Type)
+
+		for _, sample := range family.Samples {
+			// StatsD has no representation for NaN, infinities or staleness
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+
+			name := sample.Name
+			if sample.Field != "" {
+				name += "." + sample.Field
+			}
+
+			line := sanitizeStatsdName(name) + ":" + formatValue(sample.Value) + "|" + suffix
+
+			var tags []string
+			for _, label := range sample.Labels {
+				if label.Name == nameLabel {
+					continue
+				}
+				tags = append(tags, sanitizeStatsdTag(label.Name)+":"+sanitizeStatsdTag(label.Value))
+			}
+			if len(tags) > 0 {
+				line += "|#" + strings.Join(tags, ",")
+			}
+
+			// DogStatsD accepts an explicit timestamp in seconds
+			if a.timestamp {
+				line += "|T" + strconv.FormatInt(sample.Timestamp/1000, 10)
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// sendStatsd sends DogStatsD lines over UDP, packing as many newline separated
+// lines into each datagram as fit
+func sendStatsd(address string, lines []string) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to StatsD agent at '%s': %w", address, err)
+	}
+	defer conn.Close()
+
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write([]byte(packet.String()))
+		packet.Reset()
+		if err != nil {
+			return fmt.Errorf("failed to send metrics to StatsD agent at '%s': %w", address, err)
+		}
+		return nil
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsdPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	return flush()
+}
+
+// sanitizeStatsdName replaces characters that delimit a StatsD line and
+// control characters
+func sanitizeStatsdName(name string) string {
+	return replaceControlChars(strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_").Replace(name), "_")
+}
+
+// sanitizeStatsdTag replaces characters that delimit DogStatsD tags and
+// control characters
+func sanitizeStatsdTag(tag string) string {
+	return replaceControlChars(strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(tag), "_")
+}
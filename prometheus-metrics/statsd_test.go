@@ -0,0 +1,131 @@
+package prometheusmetrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivity_Eval_DogStatsDFormat(t *testing.T) {
+	act := &Activity{
+		metricType:   "gauge",
+		metricName:   "system_metrics",
+		includeHelp:  true,
+		includeType:  true,
+		timestamp:    true,
+		outputFormat: formatDogStatsD,
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"cpu_usage": 75.2,
+			"host":      "server-01",
+			"env":       "prod,eu",
+			"timestamp": int64(1705316200000),
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "system_metrics.cpu_usage:75.2|g|#env:prod_eu,host:server-01|T1705316200", tc.GetOutput("formattedMetrics"))
+	// The Prometheus output is always populated
+	assert.Contains(t, tc.GetOutput("prometheusMetric"), `system_metrics{name="cpu_usage",env="prod,eu",host="server-01"} 75.2`)
+}
+
+func TestStatsdTypeMapping(t *testing.T) {
+	tests := map[string]string{
+		"gauge":     "g",
+		"counter":   "c",
+		"histogram": "h",
+		"summary":   "ms",
+	}
+
+	for metricType, expected := range tests {
+		act := &Activity{metricType: metricType, metricName: "requests", temporality: temporalityCumulativeToDelta}
+		result, err := act.convertToPrometheusFormat(map[string]interface{}{"count": 3})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"requests.count:3|" + expected}, act.renderStatsd(result), "Type: %s", metricType)
+	}
+}
+
+func TestActivity_Eval_DogStatsDCounters(t *testing.T) {
+	eval := func(act *Activity, count int, timestamp int64) string {
+		tc := test.NewActivityContext(act.Metadata())
+		tc.SetInputObject(&Input{MetricData: map[string]interface{}{"count": count, "timestamp": timestamp}})
+		done, err := act.Eval(tc)
+		require.NoError(t, err)
+		assert.True(t, done)
+		return tc.GetOutput("formattedMetrics").(string)
+	}
+
+	// Cumulative counts are gauges, a |c line would be added to the
+	// agent's count on every push
+	cumulative := &Activity{metricType: "counter", metricName: "requests", outputFormat: formatDogStatsD, temporality: temporalityNone}
+	assert.Equal(t, "requests.count:10|g", eval(cumulative, 10, 1000))
+	assert.Equal(t, "requests.count:25|g", eval(cumulative, 25, 2000))
+
+	// Deltas are sent as increments
+	delta := &Activity{metricType: "counter", metricName: "requests", outputFormat: formatDogStatsD,
+		temporality: temporalityCumulativeToDelta, firstSeen: firstSeenDrop}
+	assert.Equal(t, "", eval(delta, 10, 1000))
+	assert.Equal(t, "requests.count:15|c", eval(delta, 25, 2000))
+	assert.Equal(t, "requests.count:5|c", eval(delta, 30, 3000))
+}
+
+func TestActivity_Eval_SendStatsd(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	act := &Activity{
+		metricType:    "counter",
+		metricName:    "http_requests",
+		statsdAddress: listener.LocalAddr().String(),
+	}
+
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{
+		MetricData: map[string]interface{}{
+			"metrics": []interface{}{
+				map[string]interface{}{"count": 150, "method": "GET"},
+				map[string]interface{}{"count": 7, "method": "POST"},
+			},
+		},
+	})
+
+	done, err := act.Eval(tc)
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	buf := make([]byte, maxStatsdPacketSize)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, _, err := listener.ReadFrom(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "http_requests.count:150|g|#method:GET\nhttp_requests.count:7|g|#method:POST", string(buf[:n]))
+}
+
+func TestSendStatsd_SplitsPackets(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	line := "metric." + strings.Repeat("x", 1000) + ":1|g"
+	require.NoError(t, sendStatsd(listener.LocalAddr().String(), []string{line, line}))
+
+	buf := make([]byte, 2*maxStatsdPacketSize)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, listener.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, _, err := listener.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, line, string(buf[:n]))
+	}
+}
@@ -0,0 +1,222 @@
+package prometheusmetrics
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+)
+
+// Supported values of the temporality setting
+const (
+	temporalityNone              = "none"
+	temporalityDeltaToCumulative = "deltaToCumulative"
+	temporalityCumulativeToDelta = "cumulativeToDelta"
+)
+
+// Defaults of the seriesTTL and maxSeries settings
+const (
+	defaultSeriesTTL = 3600 // seconds
+	defaultMaxSeries = 10000
+)
+
+// Supported values of the firstSeen setting
+const (
+	firstSeenDrop = "drop"
+	firstSeenEmit = "emit"
+)
+
+// seriesState is what the temporality conversion remembers about a series
+// between Evals
+type seriesState struct {
+	value     float64 // last cumulative value or running total
+	start     int64   // start of the current cumulative run, ms
+	timestamp int64   // timestamp of the last sample, ms
+
+	key     string
+	updated time.Time // wall clock time of the last sample
+}
+
+// temporalityTracker holds per-series state keyed on the series name and
+// label set. It is safe for concurrent Evals.
+//
+// Label sets that churn (request IDs, pod names) would grow the state
+// forever, so a series is forgotten when it has not been updated for ttl or
+// when maxSeries is exceeded, least recently updated first. A forgotten
+// series starts over: its next sample is handled like a first sample.
+type temporalityTracker struct {
+	mu     sync.Mutex
+	series map[string]*list.Element
+	order  *list.List // of *seriesState, front is the most recently updated
+
+	ttl       time.Duration // 0 keeps series regardless of age
+	maxSeries int           // 0 for no limit
+	now       func() time.Time
+}
+
+// get returns the state of a series and marks it as updated
+func (t *temporalityTracker) get(key string) (*seriesState, bool) {
+	element, ok := t.series[key]
+	if !ok {
+		return nil, false
+	}
+	state := element.Value.(*seriesState)
+	state.updated = t.now()
+	t.order.MoveToFront(element)
+	return state, true
+}
+
+// add starts tracking a series, evicting the least recently updated series
+// beyond maxSeries
+func (t *temporalityTracker) add(key string, state *seriesState) {
+	state.key = key
+	state.updated = t.now()
+	t.series[key] = t.order.PushFront(state)
+	for t.maxSeries > 0 && t.order.Len() > t.maxSeries {
+		t.remove(t.order.Back())
+	}
+}
+
+// forget stops tracking a series
+func (t *temporalityTracker) forget(key string) {
+	if element, ok := t.series[key]; ok {
+		t.remove(element)
+	}
+}
+
+func (t *temporalityTracker) remove(element *list.Element) {
+	t.order.Remove(element)
+	delete(t.series, element.Value.(*seriesState).key)
+}
+
+// expire forgets the series that have not been updated for ttl
+func (t *temporalityTracker) expire() {
+	if t.ttl <= 0 {
+		return
+	}
+	cutoff := t.now().Add(-t.ttl)
+	for element := t.order.Back(); element != nil && element.Value.(*seriesState).updated.Before(cutoff); element = t.order.Back() {
+		t.remove(element)
+	}
+}
+
+// len returns the number of tracked series
+func (t *temporalityTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.series)
+}
+
+// seriesKey identifies a series independently of the label order
+func seriesKey(sample metricSample) string {
+	labels := make([]string, 0, len(sample.Labels))
+	for _, label := range sample.Labels {
+		labels = append(labels, label.Name+"\xff"+label.Value)
+	}
+	sort.Strings(labels)
+	return sample.Name + "\xfe" + strings.Join(labels, "\xfe")
+}
+
+// applyTemporality converts counter samples between delta and cumulative
+// temporality in place. Samples that have no output yet (first seen with
+// firstSeen "drop"), arrive out of order, or carry a negative delta are
+// removed and counted as skipped.
+func (a *Activity) applyTemporality(result *conversionResult, logger log.Logger) {
+	if a.temporality == "" || a.temporality == temporalityNone {
+		return
+	}
+
+	a.tracker.mu.Lock()
+	defer a.tracker.mu.Unlock()
+	if a.tracker.series == nil {
+		a.tracker.series = make(map[string]*list.Element)
+		a.tracker.order = list.New()
+	}
+	if a.tracker.now == nil {
+		a.tracker.now = time.Now
+	}
+	a.tracker.expire()
+
+	for _, family := range result.Families {
+		if family.Type != "counter" {
+			continue
+		}
+
+		samples := family.Samples[:0]
+		for _, sample := range family.Samples {
+			keep, skipped := a.convertTemporality(&sample, logger)
+			if skipped {
+				result.SkippedFields++
+			}
+			if keep {
+				samples = append(samples, sample)
+			}
+		}
+		family.Samples = samples
+	}
+}
+
+// convertTemporality updates the series state with one sample and rewrites
+// its value. It reports whether the sample is emitted and whether it was
+// rejected as invalid.
+func (a *Activity) convertTemporality(sample *metricSample, logger log.Logger) (keep bool, skipped bool) {
+	key := seriesKey(*sample)
+
+	// A stale marker ends the series; the next sample starts a new one
+	if isStaleNaN(sample.Value) {
+		a.tracker.forget(key)
+		return true, false
+	}
+	if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+		return true, false
+	}
+
+	state, seen := a.tracker.get(key)
+
+	if seen && sample.Timestamp < state.timestamp {
+		logger.Warnf("Dropping out-of-order sample of series %s: %d is before %d", sample.Name, sample.Timestamp, state.timestamp)
+		return false, true
+	}
+
+	switch a.temporality {
+	case temporalityDeltaToCumulative:
+		if sample.Value < 0 {
+			logger.Warnf("Dropping negative delta %v of counter %s", sample.Value, sample.Name)
+			return false, true
+		}
+		if !seen {
+			a.tracker.add(key, &seriesState{value: sample.Value, start: sample.Timestamp, timestamp: sample.Timestamp})
+			sample.StartTimestamp = sample.Timestamp
+			return a.firstSeen == firstSeenEmit, false
+		}
+		state.value += sample.Value
+		state.timestamp = sample.Timestamp
+		sample.Value = state.value
+		sample.StartTimestamp = state.start
+
+	case temporalityCumulativeToDelta:
+		if !seen {
+			a.tracker.add(key, &seriesState{value: sample.Value, start: sample.Timestamp, timestamp: sample.Timestamp})
+			// Without a previous value the whole count is taken as the delta
+			sample.StartTimestamp = sample.Timestamp
+			return a.firstSeen == firstSeenEmit, false
+		}
+		previous := state.value
+		sample.StartTimestamp = state.timestamp
+		state.value = sample.Value
+		state.timestamp = sample.Timestamp
+		if sample.Value < previous {
+			// Counter reset: the source restarted from zero
+			logger.Debugf("Counter reset detected for series %s: %v < %v", sample.Name, sample.Value, previous)
+			state.start = sample.Timestamp
+			return true, false
+		}
+		sample.Value -= previous
+	}
+
+	return true, false
+}
@@ -0,0 +1,225 @@
+package prometheusmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/core/support/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// evalCounter runs one Eval of a counter activity and returns its output
+func evalCounter(t *testing.T, act *Activity, data map[string]interface{}) (string, int) {
+	tc := test.NewActivityContext(act.Metadata())
+	tc.SetInputObject(&Input{MetricData: data})
+	done, err := act.Eval(tc)
+	require.NoError(t, err)
+	assert.True(t, done)
+	return tc.GetOutput("prometheusMetric").(string), tc.GetOutput("skippedFields").(int)
+}
+
+func TestTemporality_CumulativeToDelta(t *testing.T) {
+	act := &Activity{
+		metricType:  "counter",
+		metricName:  "requests_total",
+		temporality: temporalityCumulativeToDelta,
+		firstSeen:   firstSeenDrop,
+	}
+
+	output, _ := evalCounter(t, act, map[string]interface{}{"timestamp": 1000, "success": 100, "service": "api"})
+	assert.Equal(t, "", output, "first sample only sets the reference")
+
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 130, "service": "api"})
+	assert.Equal(t, `requests_total{name="success",service="api"} 30`, output)
+
+	// A different label set is a different series
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 5, "service": "web"})
+	assert.Equal(t, "", output)
+
+	// Counter reset: the new value is the delta since the restart
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 3000, "success": 12, "service": "api"})
+	assert.Equal(t, `requests_total{name="success",service="api"} 12`, output)
+
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 4000, "success": 20, "service": "api"})
+	assert.Equal(t, `requests_total{name="success",service="api"} 8`, output)
+}
+
+func TestTemporality_DeltaToCumulative(t *testing.T) {
+	act := &Activity{
+		metricType:  "counter",
+		metricName:  "requests_total",
+		temporality: temporalityDeltaToCumulative,
+		firstSeen:   firstSeenEmit,
+	}
+
+	output, _ := evalCounter(t, act, map[string]interface{}{"timestamp": 1000, "success": 10})
+	assert.Equal(t, `requests_total{name="success"} 10`, output)
+
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 5})
+	assert.Equal(t, `requests_total{name="success"} 15`, output)
+
+	// Negative deltas and out-of-order samples are rejected
+	output, skipped := evalCounter(t, act, map[string]interface{}{"timestamp": 3000, "success": -1, "errors": 2})
+	assert.Equal(t, `requests_total{name="errors"} 2`, output)
+	assert.Equal(t, 1, skipped)
+
+	output, skipped = evalCounter(t, act, map[string]interface{}{"timestamp": 1500, "success": 1})
+	assert.Equal(t, "", output)
+	assert.Equal(t, 1, skipped)
+
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 4000, "success": 1})
+	assert.Equal(t, `requests_total{name="success"} 16`, output)
+}
+
+func TestTemporality_DeltaToCumulativeDropFirst(t *testing.T) {
+	act := &Activity{
+		metricType:  "counter",
+		metricName:  "requests_total",
+		temporality: temporalityDeltaToCumulative,
+		firstSeen:   firstSeenDrop,
+	}
+
+	output, _ := evalCounter(t, act, map[string]interface{}{"timestamp": 1000, "success": 10})
+	assert.Equal(t, "", output)
+
+	// The dropped first delta still counts toward the total
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 5})
+	assert.Equal(t, `requests_total{name="success"} 15`, output)
+}
+
+func TestTemporality_StaleMarkerResetsSeries(t *testing.T) {
+	act := &Activity{
+		metricType:   "counter",
+		metricName:   "requests_total",
+		temporality:  temporalityCumulativeToDelta,
+		firstSeen:    firstSeenEmit,
+		nullHandling: nullStale,
+	}
+
+	output, _ := evalCounter(t, act, map[string]interface{}{"timestamp": 1000, "success": 100})
+	assert.Equal(t, `requests_total{name="success"} 100`, output)
+
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": nil})
+	assert.Equal(t, `requests_total{name="success"} NaN`, output)
+
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 3000, "success": 40})
+	assert.Equal(t, `requests_total{name="success"} 40`, output)
+}
+
+func TestTemporality_SeriesTTL(t *testing.T) {
+	now := time.Unix(1705316200, 0)
+	act := &Activity{
+		metricType:  "counter",
+		metricName:  "requests_total",
+		temporality: temporalityCumulativeToDelta,
+		firstSeen:   firstSeenDrop,
+		tracker:     temporalityTracker{ttl: time.Minute, now: func() time.Time { return now }},
+	}
+
+	evalCounter(t, act, map[string]interface{}{"timestamp": 1000, "success": 100, "pod": "a"})
+	evalCounter(t, act, map[string]interface{}{"timestamp": 1000, "success": 7, "pod": "b"})
+	assert.Equal(t, 2, act.tracker.len())
+
+	now = now.Add(50 * time.Second)
+	output, _ := evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 130, "pod": "a"})
+	assert.Equal(t, `requests_total{name="success",pod="a"} 30`, output)
+
+	// Pod b has not reported for over a minute and is forgotten; pod a was
+	// updated 20 seconds ago and is kept
+	now = now.Add(20 * time.Second)
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 3000, "success": 150, "pod": "a"})
+	assert.Equal(t, `requests_total{name="success",pod="a"} 20`, output)
+	assert.Equal(t, 1, act.tracker.len())
+
+	// A forgotten series starts over as first seen
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 3000, "success": 9, "pod": "b"})
+	assert.Equal(t, "", output)
+}
+
+func TestTemporality_MaxSeries(t *testing.T) {
+	act := &Activity{
+		metricType:  "counter",
+		metricName:  "requests_total",
+		temporality: temporalityCumulativeToDelta,
+		firstSeen:   firstSeenDrop,
+		tracker:     temporalityTracker{maxSeries: 2},
+	}
+
+	// A churning label keeps the state at two series
+	for i, pod := range []string{"a", "b", "c", "d"} {
+		evalCounter(t, act, map[string]interface{}{"timestamp": 1000 + i, "success": 10, "pod": pod})
+		assert.LessOrEqual(t, act.tracker.len(), 2)
+	}
+
+	// The least recently updated series were evicted
+	output, _ := evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 15, "pod": "d"})
+	assert.Equal(t, `requests_total{name="success",pod="d"} 5`, output)
+	output, _ = evalCounter(t, act, map[string]interface{}{"timestamp": 2000, "success": 15, "pod": "a"})
+	assert.Equal(t, "", output)
+}
+
+func TestTemporality_OTLP(t *testing.T) {
+	act := &Activity{
+		metricType:  "counter",
+		metricName:  "requests_total",
+		temporality: temporalityCumulativeToDelta,
+		firstSeen:   firstSeenDrop,
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"timestamp": 1000, "success": 100},
+		{"timestamp": 2000, "success": 130},
+	} {
+		result, err := act.convertToPrometheusFormat(data)
+		require.NoError(t, err)
+		act.applyTemporality(result, log.RootLogger())
+
+		if data["timestamp"] == 2000 {
+			sum := act.buildOTLP(result).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetSum()
+			assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, sum.AggregationTemporality)
+			require.Len(t, sum.DataPoints, 1)
+			assert.Equal(t, 30.0, sum.DataPoints[0].GetAsDouble())
+			assert.Equal(t, uint64(1000_000_000), sum.DataPoints[0].StartTimeUnixNano)
+			assert.Equal(t, uint64(2000_000_000), sum.DataPoints[0].TimeUnixNano)
+		}
+	}
+}
+
+func TestNew_TemporalityRequiresCounter(t *testing.T) {
+	_, err := New(test.NewActivityInitContext(map[string]interface{}{
+		"metricType":  "gauge",
+		"temporality": temporalityDeltaToCumulative,
+	}, nil))
+	assert.Error(t, err)
+
+	_, err = New(test.NewActivityInitContext(map[string]interface{}{
+		"metricType":  "counter",
+		"temporality": "sometimes",
+	}, nil))
+	assert.Error(t, err)
+
+	act, err := New(test.NewActivityInitContext(map[string]interface{}{
+		"metricType":  "counter",
+		"temporality": temporalityCumulativeToDelta,
+	}, nil))
+	require.NoError(t, err)
+	assert.Equal(t, firstSeenDrop, act.(*Activity).firstSeen)
+	assert.Equal(t, time.Hour, act.(*Activity).tracker.ttl)
+	assert.Equal(t, defaultMaxSeries, act.(*Activity).tracker.maxSeries)
+
+	act, err = New(test.NewActivityInitContext(map[string]interface{}{
+		"metricType":  "counter",
+		"temporality": temporalityCumulativeToDelta,
+		"seriesTTL":   0,
+		"maxSeries":   500,
+	}, nil))
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), act.(*Activity).tracker.ttl)
+	assert.Equal(t, 500, act.(*Activity).tracker.maxSeries)
+
+	_, err = New(test.NewActivityInitContext(map[string]interface{}{"maxSeries": -1}, nil))
+	assert.Error(t, err)
+}